@@ -1,8 +1,10 @@
 package config
 
 import (
-	"log"
+	"log/slog"
 	"os"
+	"strconv"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -16,13 +18,79 @@ type Config struct {
 	SessionSecret  string
 	Port           string
 	FrontendURL    string
+
+	// Log level (debug, info, warn, error) and output format (text or json)
+	LogLevel  string
+	LogFormat string
+
+	// Allowed clock difference and maximum age when validating logout tokens.
+	// A maximum age of 0 disables the age check, leaving only exp.
+	LogoutTokenClockSkew time.Duration
+	LogoutTokenMaxAge    time.Duration
+
+	// Session store backend: "memory" or "file"
+	SessionStore     string
+	SessionStorePath string
+
+	// The memory session store is saved here on shutdown and restored on
+	// startup; the directory must survive restarts (a volume in k8s)
+	SessionSnapshotPath string
+
+	// Sessions end after SessionIdleTimeout without requests or
+	// SessionMaxLifetime after login; the sweeper checks every interval
+	SessionIdleTimeout   time.Duration
+	SessionMaxLifetime   time.Duration
+	SessionSweepInterval time.Duration
+
+	// How often session tokens close to expiry are refreshed. Only sessions
+	// used within TokenRefreshActiveWindow are refreshed.
+	TokenRefreshInterval     time.Duration
+	TokenRefreshActiveWindow time.Duration
+
+	// Audience that bearer access tokens must carry (defaults to ClientID).
+	// Keycloak access tokens only carry it with an audience mapper on the
+	// client scope used by the token's client.
+	BearerAudience string
+
+	// Realm role required for the session administration API
+	AdminRole string
+
+	// Keycloak admin REST API access for IdP-side forced logout. The admin
+	// client defaults to ClientID/ClientSecret.
+	IdPAdminEnabled   bool
+	AdminClientID     string
+	AdminClientSecret string
+
+	// When true, logout fails if the IdP does not confirm token revocation
+	RevocationRequired bool
+
+	// Notification delivery: events queued per subscriber, the policy for a
+	// full queue (drop_oldest, disconnect or coalesce) and events waiting to
+	// be published
+	NotifyQueueSize         int
+	NotifyDropPolicy        string
+	NotifyDispatchQueueSize int
+
+	// On SIGTERM or SIGINT the server stops within ShutdownTimeout. Event
+	// stream clients are told to reconnect after ShutdownRetryHint.
+	ShutdownTimeout   time.Duration
+	ShutdownRetryHint time.Duration
+
+	// Event bus used to reach subscribers on other replicas: "local" or "tcp".
+	// EventBusAddr is the broker to connect to; if EventBusBrokerListen is
+	// set, this process also runs the broker. Frames on the TCP bus are
+	// signed with EventBusSecret, which every replica and the broker share.
+	EventBus             string
+	EventBusAddr         string
+	EventBusBrokerListen string
+	EventBusSecret       string
 }
 
 // LoadConfig loads configuration from environment variables
 func LoadConfig() *Config {
 	// Try to load .env file (optional)
 	if err := godotenv.Load(); err != nil {
-		log.Printf("Warning: .env file not found: %v", err)
+		slog.Warn(".env file not found", "error", err)
 	}
 
 	config := &Config{
@@ -33,11 +101,50 @@ func LoadConfig() *Config {
 		SessionSecret: getEnv("SESSION_SECRET", "default-session-secret-for-development"),
 		Port:          getEnv("PORT", "3001"),
 		FrontendURL:   getEnv("FRONTEND_URL", "http://localhost:3000"),
+
+		LogLevel:  getEnv("LOG_LEVEL", "info"),
+		LogFormat: getEnv("LOG_FORMAT", "text"),
+
+		LogoutTokenClockSkew: getEnvDuration("LOGOUT_TOKEN_CLOCK_SKEW", 30*time.Second),
+		LogoutTokenMaxAge:    getEnvDuration("LOGOUT_TOKEN_MAX_AGE", 2*time.Minute),
+
+		SessionStore:     getEnv("SESSION_STORE", "memory"),
+		SessionStorePath: getEnv("SESSION_STORE_PATH", "data/sessions.jsonl"),
+
+		SessionSnapshotPath: getEnv("SESSION_SNAPSHOT_PATH", "data/sessions-snapshot.json"),
+
+		SessionIdleTimeout:   getEnvDuration("SESSION_IDLE_TIMEOUT", 30*time.Minute),
+		SessionMaxLifetime:   getEnvDuration("SESSION_MAX_LIFETIME", 24*time.Hour),
+		SessionSweepInterval: getEnvDuration("SESSION_SWEEP_INTERVAL", time.Minute),
+		TokenRefreshInterval: getEnvDuration("TOKEN_REFRESH_INTERVAL", time.Minute),
+
+		TokenRefreshActiveWindow: getEnvDuration("TOKEN_REFRESH_ACTIVE_WINDOW", 5*time.Minute),
+
+		AdminRole:          getEnv("ADMIN_ROLE", "admin"),
+		RevocationRequired: getEnvBool("REVOKE_TOKENS_REQUIRED", false),
+
+		NotifyQueueSize:         getEnvInt("NOTIFY_QUEUE_SIZE", 16),
+		NotifyDropPolicy:        getEnv("NOTIFY_DROP_POLICY", "drop_oldest"),
+		NotifyDispatchQueueSize: getEnvInt("NOTIFY_DISPATCH_QUEUE_SIZE", 1024),
+
+		ShutdownTimeout:   getEnvDuration("SHUTDOWN_TIMEOUT", 20*time.Second),
+		ShutdownRetryHint: getEnvDuration("SHUTDOWN_RETRY_HINT", 5*time.Second),
+
+		EventBus:             getEnv("EVENT_BUS", "local"),
+		EventBusAddr:         getEnv("EVENT_BUS_ADDR", "localhost:7070"),
+		EventBusBrokerListen: getEnv("EVENT_BUS_BROKER_LISTEN", ""),
+		EventBusSecret:       getEnv("EVENT_BUS_SECRET", ""),
 	}
 
+	config.BearerAudience = getEnv("BEARER_AUDIENCE", config.ClientID)
+	config.IdPAdminEnabled = getEnvBool("KEYCLOAK_ADMIN_ENABLED", false)
+	config.AdminClientID = getEnv("KEYCLOAK_ADMIN_CLIENT_ID", config.ClientID)
+	config.AdminClientSecret = getEnv("KEYCLOAK_ADMIN_CLIENT_SECRET", config.ClientSecret)
+
 	// Validate required fields
 	if config.ClientSecret == "" {
-		log.Fatal("CLIENT_SECRET environment variable is required")
+		slog.Error("CLIENT_SECRET environment variable is required")
+		os.Exit(1)
 	}
 
 	return config
@@ -51,6 +158,48 @@ func getEnv(key, fallback string) string {
 	return fallback
 }
 
+// getEnvBool gets a boolean environment variable with fallback
+func getEnvBool(key string, fallback bool) bool {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		slog.Warn("invalid boolean in environment, using fallback", "key", key, "value", value, "fallback", fallback)
+		return fallback
+	}
+	return b
+}
+
+// getEnvInt gets a positive integer environment variable with fallback
+func getEnvInt(key string, fallback int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		slog.Warn("invalid integer in environment, using fallback", "key", key, "value", value, "fallback", fallback)
+		return fallback
+	}
+	return n
+}
+
+// getEnvDuration gets a duration environment variable (e.g. "30s") with fallback
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		slog.Warn("invalid duration in environment, using fallback", "key", key, "value", value, "fallback", fallback)
+		return fallback
+	}
+	return d
+}
+
 // IsHTTPS checks if the frontend URL uses HTTPS
 func (c *Config) IsHTTPS() bool {
 	return len(c.FrontendURL) >= 8 && c.FrontendURL[:8] == "https://"
@@ -61,9 +210,15 @@ func (c *Config) GetIssuerURL() string {
 	return c.KeycloakURL + "/realms/" + c.KeycloakRealm
 }
 
-// GetLogoutURL returns the Keycloak logout URL
-func (c *Config) GetLogoutURL() string {
-	return c.KeycloakURL + "/realms/" + c.KeycloakRealm + "/protocol/openid-connect/logout?redirect_uri=" + c.FrontendURL
+// GetAdminAPIURL returns the Keycloak admin REST API base URL of the realm
+func (c *Config) GetAdminAPIURL() string {
+	return c.KeycloakURL + "/admin/realms/" + c.KeycloakRealm
+}
+
+// GetEndSessionEndpoint returns the Keycloak end-session (logout) endpoint.
+// It is used when the provider metadata does not advertise one.
+func (c *Config) GetEndSessionEndpoint() string {
+	return c.GetIssuerURL() + "/protocol/openid-connect/logout"
 }
 
 // IsLocalDevelopment checks if running in local development environment
@@ -77,4 +232,13 @@ func (c *Config) GetRedirectURL() string {
 		return "http://localhost:" + c.Port + "/auth/callback"
 	}
 	return c.FrontendURL + "/auth/callback"
+}
+
+// GetPostLogoutRedirectURL returns where Keycloak sends the browser after
+// RP-initiated logout
+func (c *Config) GetPostLogoutRedirectURL() string {
+	if c.IsLocalDevelopment() {
+		return "http://localhost:" + c.Port + "/auth/logout/callback"
+	}
+	return c.FrontendURL + "/auth/logout/callback"
 }
\ No newline at end of file
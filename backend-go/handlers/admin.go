@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"keycloak-logout-backend-go/models"
+	"keycloak-logout-backend-go/services"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+// AdminHandler handles session administration requests
+type AdminHandler struct {
+	sessionService *services.SessionService
+	forcedLogout   *services.ForcedLogoutService
+}
+
+// NewAdminHandler creates a new admin handler
+func NewAdminHandler(sessionSvc *services.SessionService, forcedLogout *services.ForcedLogoutService) *AdminHandler {
+	return &AdminHandler{
+		sessionService: sessionSvc,
+		forcedLogout:   forcedLogout,
+	}
+}
+
+// HandleListSessions returns active sessions, newest first, filtered by
+// userId and a case-insensitive q matched against user names, and paginated
+// with page (1-based) and pageSize
+func (h *AdminHandler) HandleListSessions(c *gin.Context) {
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid page"})
+		return
+	}
+	pageSize, err := strconv.Atoi(c.DefaultQuery("pageSize", strconv.Itoa(defaultPageSize)))
+	if err != nil || pageSize < 1 || pageSize > maxPageSize {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid pageSize"})
+		return
+	}
+	userID := c.Query("userId")
+	query := strings.ToLower(c.Query("q"))
+
+	var matched []*models.SessionData
+	for _, session := range h.sessionService.GetAllSessions(c.Request.Context()) {
+		if userID != "" && session.User.ID != userID {
+			continue
+		}
+		if query != "" && !matchesQuery(session.User, query) {
+			continue
+		}
+		matched = append(matched, session)
+	}
+
+	sort.Slice(matched, func(i, j int) bool {
+		if !matched[i].LoginTime.Equal(matched[j].LoginTime) {
+			return matched[i].LoginTime.After(matched[j].LoginTime)
+		}
+		return matched[i].SessionID < matched[j].SessionID
+	})
+
+	start := (page - 1) * pageSize
+	if start > len(matched) {
+		start = len(matched)
+	}
+	end := start + pageSize
+	if end > len(matched) {
+		end = len(matched)
+	}
+
+	sessionList := make([]gin.H, 0, end-start)
+	for _, session := range matched[start:end] {
+		summary := sessionSummary(session)
+		summary["sid"] = session.IdPSessionID
+		sessionList = append(sessionList, summary)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"sessions": sessionList,
+		"page":     page,
+		"pageSize": pageSize,
+		"total":    len(matched),
+	})
+}
+
+// HandleDeleteSession kills a single session. With idpLogout=true the
+// matching Keycloak session is ended as well. If another replica may hold the
+// session the logout is forwarded to it and 202 is returned; without other
+// replicas an unknown session is 404.
+func (h *AdminHandler) HandleDeleteSession(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+	defer cancel()
+
+	result, err := h.forcedLogout.LogoutSession(ctx, c.Param("id"), c.Query("idpLogout") == "true")
+	if errors.Is(err, services.ErrSessionNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Session not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Forced logout failed"})
+		return
+	}
+
+	// A session held by another replica is ended there asynchronously
+	if result.Forwarded {
+		c.JSON(http.StatusAccepted, result)
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// HandleDeleteUserSessions kills every session of a user. With
+// idpLogout=true the user is logged out of Keycloak as well.
+func (h *AdminHandler) HandleDeleteUserSessions(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+	defer cancel()
+
+	result, err := h.forcedLogout.LogoutUser(ctx, c.Param("id"), c.Query("idpLogout") == "true")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Forced logout failed"})
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// matchesQuery reports whether a lower-cased query matches the user's names
+func matchesQuery(user models.UserProfile, query string) bool {
+	for _, value := range []string{user.ID, user.Username, user.DisplayName} {
+		if strings.Contains(strings.ToLower(value), query) {
+			return true
+		}
+	}
+	return false
+}
+
+// HandleListSubscribers returns this replica's event subscribers with their
+// queue depth and dropped event counts, and the dispatch queue depth
+func (h *AdminHandler) HandleListSubscribers(c *gin.Context) {
+	stats := h.sessionService.SubscriberStats()
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].ID < stats[j].ID
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"dropPolicy":         h.sessionService.DropPolicy(),
+		"dispatchQueueDepth": h.sessionService.DispatchQueueDepth(),
+		"subscribers":        stats,
+	})
+}
@@ -0,0 +1,136 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"keycloak-logout-backend-go/handlers"
+	"keycloak-logout-backend-go/models"
+	"keycloak-logout-backend-go/services"
+	"keycloak-logout-backend-go/services/storetest"
+)
+
+// newAdminRouter serves the admin endpoints, without authentication, on the
+// given session service
+func newAdminRouter(sessionService *services.SessionService) *gin.Engine {
+	admin := handlers.NewAdminHandler(sessionService, services.NewForcedLogoutService(sessionService, nil))
+	r := gin.New()
+	r.GET("/api/admin/sessions", admin.HandleListSessions)
+	r.DELETE("/api/admin/sessions/:id", admin.HandleDeleteSession)
+	return r
+}
+
+// serve sends a request and decodes the JSON response
+func serve(t *testing.T, r http.Handler, method, target string) (int, map[string]interface{}) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(method, target, nil))
+	var body map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("%s %s: decode body %q: %v", method, target, w.Body.String(), err)
+	}
+	return w.Code, body
+}
+
+func TestDeleteSession(t *testing.T) {
+	sessionService := newSessionService(t, services.NewLocalEventBus())
+	addSession(t, sessionService, "session-1", "user-1", "sid-1")
+	r := newAdminRouter(sessionService)
+
+	if code, body := serve(t, r, http.MethodDelete, "/api/admin/sessions/session-1"); code != http.StatusOK || body["removed"] != 1.0 {
+		t.Errorf("DELETE existing session: %d %v; want 200 with one removed", code, body)
+	}
+	if code, _ := serve(t, r, http.MethodDelete, "/api/admin/sessions/session-1"); code != http.StatusNotFound {
+		t.Errorf("DELETE unknown session without other replicas: %d; want 404", code)
+	}
+}
+
+func TestDeleteSessionForwardedToOtherReplica(t *testing.T) {
+	bus := services.NewLocalEventBus()
+	sessionService := newSessionService(t, bus)
+	newSessionService(t, bus)
+	r := newAdminRouter(sessionService)
+
+	code, body := serve(t, r, http.MethodDelete, "/api/admin/sessions/elsewhere")
+	if code != http.StatusAccepted || body["forwarded"] != true {
+		t.Errorf("DELETE session with other replicas: %d %v; want 202 forwarded", code, body)
+	}
+}
+
+func TestListSessions(t *testing.T) {
+	sessionService := newSessionService(t, services.NewLocalEventBus())
+	now := time.Now()
+	for i, user := range []models.UserProfile{
+		{ID: "user-1", Username: "alice"},
+		{ID: "user-1", Username: "alice"},
+		{ID: "user-2", Username: "bob", DisplayName: "Bob Builder"},
+		{ID: "user-3", Username: "carol"},
+		{ID: "user-2", Username: "bob", DisplayName: "Bob Builder"},
+	} {
+		// s1 logged in first, s5 last
+		session := storetest.NewSession(fmt.Sprintf("s%d", i+1), user.ID, "")
+		session.User = user
+		session.LoginTime = now.Add(time.Duration(i-5) * time.Minute)
+		if err := sessionService.AddSession(session); err != nil {
+			t.Fatalf("AddSession: %v", err)
+		}
+	}
+	r := newAdminRouter(sessionService)
+
+	tests := []struct {
+		query string
+		want  []string
+		total float64
+	}{
+		{"", []string{"s5", "s4", "s3", "s2", "s1"}, 5},
+		{"?page=2&pageSize=2", []string{"s3", "s2"}, 5},
+		{"?page=4&pageSize=2", []string{}, 5},
+		{"?userId=user-1", []string{"s2", "s1"}, 2},
+		{"?q=BUILDER", []string{"s5", "s3"}, 2},
+		{"?q=bob&pageSize=1", []string{"s5"}, 2},
+		{"?userId=user-2&q=alice", []string{}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			code, body := serve(t, r, http.MethodGet, "/api/admin/sessions"+tt.query)
+			if code != http.StatusOK {
+				t.Fatalf("status %d; want 200", code)
+			}
+			var got []string
+			for _, session := range body["sessions"].([]interface{}) {
+				got = append(got, session.(map[string]interface{})["sessionId"].(string))
+			}
+			if !equalStrings(got, tt.want) || body["total"] != tt.total {
+				t.Errorf("sessions %v, total %v; want %v, %v", got, body["total"], tt.want, tt.total)
+			}
+		})
+	}
+}
+
+func TestListSessionsRejectsBadPagination(t *testing.T) {
+	r := newAdminRouter(newSessionService(t, services.NewLocalEventBus()))
+	for _, query := range []string{"?page=0", "?page=x", "?pageSize=0", "?pageSize=101"} {
+		if code, _ := serve(t, r, http.MethodGet, "/api/admin/sessions"+query); code != http.StatusBadRequest {
+			t.Errorf("GET %s: status %d; want 400", query, code)
+		}
+	}
+}
+
+// equalStrings compares two string slices, treating nil and empty as equal
+func equalStrings(got, want []string) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -1,43 +1,45 @@
 package handlers
 
 import (
+	"encoding/json"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"time"
 
 	"github.com/gin-contrib/sessions"
 	"github.com/gin-gonic/gin"
 
+	"keycloak-logout-backend-go/config"
+	"keycloak-logout-backend-go/middleware"
 	"keycloak-logout-backend-go/models"
 	"keycloak-logout-backend-go/services"
 )
 
 // APIHandler handles API requests
 type APIHandler struct {
+	config         *config.Config
 	sessionService *services.SessionService
 }
 
 // NewAPIHandler creates a new API handler
-func NewAPIHandler(sessionSvc *services.SessionService) *APIHandler {
+func NewAPIHandler(cfg *config.Config, sessionSvc *services.SessionService) *APIHandler {
 	return &APIHandler{
+		config:         cfg,
 		sessionService: sessionSvc,
 	}
 }
 
-// HandleGetUser returns current user information
+// HandleGetUser returns current user information. It works with both cookie
+// and bearer authentication. isAdmin tells the frontend whether the session
+// administration API is available to the user.
 func (h *APIHandler) HandleGetUser(c *gin.Context) {
-	userID := c.GetString("user_id")
-
-	sessionData, exists := h.sessionService.GetSession(userID)
+	profile, exists := middleware.GetUser(c)
 	if !exists {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Session not found"})
 		return
 	}
 
-	log.Printf("User profile: %+v", sessionData.User)
-
-	profile := sessionData.User
 	name := profile.DisplayName
 	if name == "" && profile.Name.GivenName != "" {
 		name = fmt.Sprintf("%s %s", profile.Name.GivenName, profile.Name.FamilyName)
@@ -53,57 +55,56 @@ func (h *APIHandler) HandleGetUser(c *gin.Context) {
 
 	c.JSON(http.StatusOK, gin.H{
 		"user": gin.H{
-			"id":    profile.ID,
-			"name":  name,
-			"email": email,
+			"id":      profile.ID,
+			"name":    name,
+			"email":   email,
+			"isAdmin": profile.HasRealmRole(h.config.AdminRole),
 		},
 	})
 }
 
 // HandleGetSessions returns all active sessions
 func (h *APIHandler) HandleGetSessions(c *gin.Context) {
-	sessions := h.sessionService.GetAllSessions()
-	
+	sessions := h.sessionService.GetAllSessions(c.Request.Context())
+
 	sessionList := make([]gin.H, 0, len(sessions))
 	for _, session := range sessions {
-		name := session.User.DisplayName
-		if name == "" {
-			name = session.User.Username
-		}
-		if name == "" {
-			name = "Unknown"
-		}
-
-		sessionList = append(sessionList, gin.H{
-			"sessionId": session.SessionID,
-			"userId":    session.User.ID,
-			"userName":  name,
-			"loginTime": session.LoginTime.Format(time.RFC3339),
-		})
+		sessionList = append(sessionList, sessionSummary(session))
 	}
 
 	c.JSON(http.StatusOK, gin.H{"sessions": sessionList})
 }
 
+// sessionSummary returns the public view of a session
+func sessionSummary(session *models.SessionData) gin.H {
+	name := session.User.DisplayName
+	if name == "" {
+		name = session.User.Username
+	}
+	if name == "" {
+		name = "Unknown"
+	}
+
+	return gin.H{
+		"sessionId": session.SessionID,
+		"userId":    session.User.ID,
+		"userName":  name,
+		"loginTime": session.LoginTime.Format(time.RFC3339),
+		"lastSeen":  session.LastSeen.Format(time.RFC3339),
+		"expiresAt": session.ExpiresAt.Format(time.RFC3339),
+	}
+}
+
 // HandleSessionStatus returns current session status
 func (h *APIHandler) HandleSessionStatus(c *gin.Context) {
-	log.Println("Session status check called")
-	
 	session := sessions.Default(c)
-	userID := session.Get("user_id")
-	
-	isAuthenticated := userID != nil
-	log.Printf("Is authenticated: %v", isAuthenticated)
-	
+	sessionID, isAuthenticated := session.Get("session_id").(string)
+
 	var sessionActive bool
 	if isAuthenticated {
-		log.Printf("User ID: %s", userID)
-		_, sessionActive = h.sessionService.GetSession(userID.(string))
-		log.Printf("Session active: %v", sessionActive)
+		_, sessionActive = h.sessionService.GetSession(c.Request.Context(), sessionID)
 	}
-
-	sessions := h.sessionService.GetAllSessions()
-	log.Printf("Active sessions: %+v", sessions)
+	slog.DebugContext(c.Request.Context(), "session status", "authenticated", isAuthenticated, "session_active", sessionActive)
 
 	status := models.SessionStatus{
 		Authenticated: isAuthenticated,
@@ -113,30 +114,74 @@ func (h *APIHandler) HandleSessionStatus(c *gin.Context) {
 	c.JSON(http.StatusOK, status)
 }
 
-// HandleSSE handles Server-Sent Events connection
+// HandleSSEReplay lets a browser whose session ended while it was
+// disconnected collect the events it missed. EventSource reconnects carry
+// Last-Event-ID; without it, or while the session is still active, the
+// request continues to authentication and the regular SSE stream.
+func (h *APIHandler) HandleSSEReplay(c *gin.Context) {
+	missed := h.endedSessionEvents(c, sseLastEventID(c))
+	if len(missed) == 0 {
+		return
+	}
+
+	setSSEHeaders(c)
+	for _, event := range missed {
+		writeSSEEvent(c, event.ID, event.Type, event)
+	}
+	c.Writer.Flush()
+	c.Abort()
+}
+
+// endedSessionEvents returns the events after lastEventID of the cookie's
+// session if that session has ended, or nil if it is still active
+func (h *APIHandler) endedSessionEvents(c *gin.Context, lastEventID string) []models.SessionEvent {
+	sessionID, _ := sessions.Default(c).Get("session_id").(string)
+	if lastEventID == "" || sessionID == "" {
+		return nil
+	}
+	if _, active := h.sessionService.GetSession(c.Request.Context(), sessionID); active {
+		return nil
+	}
+
+	missed := h.sessionService.EventsSince(sessionID, lastEventID)
+	if len(missed) > 0 {
+		slog.InfoContext(c.Request.Context(), "replaying events of ended session", "session_id", sessionID, "events", len(missed))
+	}
+	return missed
+}
+
+// HandleSSE handles Server-Sent Events connection. Session events are sent
+// as JSON with their type as the SSE event name and their ID as the SSE id,
+// and events missed since Last-Event-ID are replayed first.
 func (h *APIHandler) HandleSSE(c *gin.Context) {
 	userID := c.GetString("user_id")
-	
-	log.Printf("SSE client connected: %s", userID)
+	sessionID := c.GetString("session_id")
 
-	// Set SSE headers
-	c.Header("Content-Type", "text/event-stream")
-	c.Header("Cache-Control", "no-cache")
-	c.Header("Connection", "keep-alive")
-	c.Header("Access-Control-Allow-Credentials", "true")
+	ctx := c.Request.Context()
+	slog.InfoContext(ctx, "SSE client connected", "session_id", sessionID)
 
-	// Create SSE client
-	client := &models.SSEClient{
-		UserID: userID,
-		C:      make(chan string, 10),
-		Done:   make(chan bool),
-	}
+	setSSEHeaders(c)
+
+	client := h.sessionService.Subscribe(services.TransportSSE, userID, sessionID)
+	defer h.sessionService.RemoveSubscriber(client.ID())
 
-	h.sessionService.AddSSEClient(userID, client)
-	defer h.sessionService.RemoveSSEClient(userID)
+	// Send initial connection message. It has no id so the browser keeps
+	// its last event ID.
+	writeSSEEvent(c, "", "connected", models.SessionEvent{
+		Type:      "connected",
+		SessionID: sessionID,
+		Timestamp: time.Now(),
+	})
 
-	// Send initial connection message
-	c.Writer.WriteString("data: connected\n\n")
+	// The client is registered before replaying, so an event may arrive both
+	// ways; replayed IDs are skipped on the live stream
+	replayed := make(map[string]bool)
+	if lastEventID := sseLastEventID(c); lastEventID != "" {
+		for _, event := range h.sessionService.EventsSince(sessionID, lastEventID) {
+			writeSSEEvent(c, event.ID, event.Type, event)
+			replayed[event.ID] = true
+		}
+	}
 	c.Writer.Flush()
 
 	// Create keepalive ticker to prevent connection timeout (every 3 seconds)
@@ -146,20 +191,66 @@ func (h *APIHandler) HandleSSE(c *gin.Context) {
 	// Handle client messages
 	for {
 		select {
-		case message := <-client.C:
-			log.Printf("📨 Sending SSE message to %s: %s", userID, message)
-			c.Writer.WriteString(fmt.Sprintf("data: %s\n\n", message))
+		case <-client.Ready():
+			for _, event := range client.Drain() {
+				if replayed[event.ID] {
+					continue
+				}
+				if event.Type == services.EventServerShutdown {
+					// retry sets the browser's reconnection delay; ending the
+					// stream lets EventSource reconnect to another replica
+					fmt.Fprintf(c.Writer, "retry: %d\n", event.RetryMs)
+					writeSSEEvent(c, "", event.Type, event)
+					c.Writer.Flush()
+					slog.InfoContext(ctx, "SSE client told to reconnect, server shutting down", "client_id", client.ID())
+					return
+				}
+				slog.DebugContext(ctx, "sending SSE event", "client_id", client.ID(), "type", event.Type, "event_id", event.ID)
+				writeSSEEvent(c, event.ID, event.Type, event)
+			}
 			c.Writer.Flush()
 		case <-keepalive.C:
 			// Send keepalive ping to prevent browser timeout
 			c.Writer.WriteString(": keepalive\n\n")
 			c.Writer.Flush()
-		case <-client.Done:
-			log.Printf("SSE client disconnected: %s", userID)
+		case <-client.Done():
+			slog.InfoContext(ctx, "SSE client disconnected by server", "client_id", client.ID())
 			return
-		case <-c.Request.Context().Done():
-			log.Printf("SSE client connection closed: %s", userID)
+		case <-ctx.Done():
+			slog.InfoContext(ctx, "SSE client connection closed", "client_id", client.ID())
 			return
 		}
 	}
-}
\ No newline at end of file
+}
+
+// setSSEHeaders prepares the response for an event stream
+func setSSEHeaders(c *gin.Context) {
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("Access-Control-Allow-Credentials", "true")
+}
+
+// writeSSEEvent writes one SSE event with a JSON payload. The id field is
+// omitted when id is empty.
+func writeSSEEvent(c *gin.Context, id, name string, payload interface{}) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "encoding SSE event failed", "error", err)
+		return
+	}
+	if id != "" {
+		fmt.Fprintf(c.Writer, "id: %s\n", id)
+	}
+	fmt.Fprintf(c.Writer, "event: %s\ndata: %s\n\n", name, data)
+}
+
+// sseLastEventID returns the ID of the last event the client received, from
+// the Last-Event-ID header sent on EventSource reconnects or the lastEventId
+// query parameter for a fresh EventSource
+func sseLastEventID(c *gin.Context) string {
+	if id := c.GetHeader("Last-Event-ID"); id != "" {
+		return id
+	}
+	return c.Query("lastEventId")
+}
@@ -0,0 +1,179 @@
+package handlers_test
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-contrib/sessions/cookie"
+	"github.com/gin-gonic/gin"
+
+	"keycloak-logout-backend-go/config"
+	"keycloak-logout-backend-go/handlers"
+	"keycloak-logout-backend-go/middleware"
+	"keycloak-logout-backend-go/models"
+	"keycloak-logout-backend-go/services"
+)
+
+// sseFixture serves the SSE endpoint as main.go wires it, with cookie
+// sessions and a route that binds the cookie to a session ID. Events are
+// published straight on the local bus, which delivers them synchronously.
+type sseFixture struct {
+	bus      *services.LocalEventBus
+	sessions *services.SessionService
+	router   *gin.Engine
+}
+
+func newSSEFixture(t *testing.T) *sseFixture {
+	t.Helper()
+	bus := services.NewLocalEventBus()
+	sessionService := newSessionService(t, bus)
+	apiHandler := handlers.NewAPIHandler(&config.Config{}, sessionService)
+
+	r := gin.New()
+	r.Use(sessions.Sessions("keycloak-session", cookie.NewStore([]byte("test-secret"))))
+	r.GET("/test/session/:id", func(c *gin.Context) {
+		session := sessions.Default(c)
+		session.Set("session_id", c.Param("id"))
+		session.Save()
+	})
+	r.GET("/api/events", apiHandler.HandleSSEReplay, middleware.RequireAuth(sessionService), apiHandler.HandleSSE)
+	return &sseFixture{bus: bus, sessions: sessionService, router: r}
+}
+
+// cookieFor returns a session cookie bound to sessionID
+func (f *sseFixture) cookieFor(t *testing.T, sessionID string) *http.Cookie {
+	t.Helper()
+	w := httptest.NewRecorder()
+	f.router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/test/session/"+sessionID, nil))
+	cookies := w.Result().Cookies()
+	if len(cookies) == 0 {
+		t.Fatal("no session cookie set")
+	}
+	return cookies[0]
+}
+
+// publish delivers a session event of user-1 with the given numeric ID
+func (f *sseFixture) publish(t *testing.T, id int, sessionID string) {
+	t.Helper()
+	err := f.bus.Publish(context.Background(), services.BusEvent{
+		UserID: "user-1",
+		SessionEvent: models.SessionEvent{
+			ID:        strconv.Itoa(id),
+			Type:      services.EventSessionInvalidated,
+			SessionID: sessionID,
+			Timestamp: time.Now(),
+		},
+	})
+	if err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+}
+
+// replay requests the event stream of the cookie's ended session after
+// lastEventID
+func (f *sseFixture) replay(t *testing.T, sessionID, lastEventID string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/api/events", nil)
+	req.Header.Set("Last-Event-ID", lastEventID)
+	req.AddCookie(f.cookieFor(t, sessionID))
+	w := httptest.NewRecorder()
+	f.router.ServeHTTP(w, req)
+	return w
+}
+
+func TestSSEReplayOfEndedSession(t *testing.T) {
+	f := newSSEFixture(t)
+	f.publish(t, 101, "ended")
+	f.publish(t, 102, "ended")
+
+	w := f.replay(t, "ended", "101")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d; want 200", w.Code)
+	}
+	body := w.Body.String()
+	if !strings.Contains(body, "id: 102\nevent: session_invalidated\n") || strings.Contains(body, "id: 101\n") {
+		t.Errorf("replay %q; want only event 102", body)
+	}
+}
+
+func TestSSEReplayWithoutMissedEvents(t *testing.T) {
+	tests := []struct {
+		name        string
+		lastEventID string
+	}{
+		{"unknown event ID", "not-an-id"},
+		{"latest event ID", "102"},
+		{"evicted events", "100"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newSSEFixture(t)
+			f.publish(t, 101, "ended")
+			f.publish(t, 102, "ended")
+			if tt.name == "evicted events" {
+				// Later events of the user's other session push those of
+				// the ended session out of the log
+				for id := 200; id < 300; id++ {
+					f.publish(t, id, "other")
+				}
+			}
+
+			// Nothing to replay, so the request goes on to authentication,
+			// which rejects the ended session
+			if w := f.replay(t, "ended", tt.lastEventID); w.Code != http.StatusUnauthorized {
+				t.Errorf("status %d, body %q; want 401", w.Code, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestSSESkipsReplayedEventsOnLiveStream(t *testing.T) {
+	f := newSSEFixture(t)
+	addSession(t, f.sessions, "s1", "user-1", "sid-1")
+	f.publish(t, 101, "s1")
+
+	server := httptest.NewServer(f.router)
+	defer server.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/api/events", nil)
+	req.Header.Set("Last-Event-ID", "100")
+	req.AddCookie(f.cookieFor(t, "s1"))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET /api/events: %v", err)
+	}
+	defer resp.Body.Close()
+
+	lines := bufio.NewScanner(resp.Body)
+	readUntil := func(want string) map[string]int {
+		t.Helper()
+		ids := make(map[string]int)
+		for lines.Scan() {
+			if id, ok := strings.CutPrefix(lines.Text(), "id: "); ok {
+				ids[id]++
+				if id == want {
+					return ids
+				}
+			}
+		}
+		t.Fatalf("stream ended before event %s: %v", want, lines.Err())
+		return nil
+	}
+
+	readUntil("101")
+	// Event 101 also reaches the live subscriber, as when it is published
+	// between subscribing and replaying; only event 102 is new
+	f.publish(t, 101, "s1")
+	f.publish(t, 102, "s1")
+	if ids := readUntil("102"); ids["101"] != 0 {
+		t.Errorf("replayed event 101 was sent again on the live stream")
+	}
+}
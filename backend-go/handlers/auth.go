@@ -2,8 +2,12 @@ package handlers
 
 import (
 	"context"
-	"log"
+	"crypto/subtle"
+	"errors"
+	"log/slog"
 	"net/http"
+	"strconv"
+	"sync/atomic"
 	"time"
 
 	"github.com/gin-contrib/sessions"
@@ -11,6 +15,8 @@ import (
 	"github.com/google/uuid"
 
 	"keycloak-logout-backend-go/config"
+	"keycloak-logout-backend-go/logging"
+	"keycloak-logout-backend-go/metrics"
 	"keycloak-logout-backend-go/models"
 	"keycloak-logout-backend-go/services"
 )
@@ -20,6 +26,7 @@ type AuthHandler struct {
 	config         *config.Config
 	authService    *services.AuthService
 	sessionService *services.SessionService
+	loginsStopped  atomic.Bool
 }
 
 // NewAuthHandler creates a new auth handler
@@ -31,74 +38,112 @@ func NewAuthHandler(cfg *config.Config, authSvc *services.AuthService, sessionSv
 	}
 }
 
+// StopLogins makes login and callback requests fail with 503 from now on,
+// so that no sessions are created while the server shuts down
+func (h *AuthHandler) StopLogins() {
+	h.loginsStopped.Store(true)
+}
+
+// rejectIfStopped answers 503 with a Retry-After hint and reports true once
+// logins have been stopped
+func (h *AuthHandler) rejectIfStopped(c *gin.Context) bool {
+	if !h.loginsStopped.Load() {
+		return false
+	}
+	c.Header("Retry-After", strconv.Itoa(int(h.config.ShutdownRetryHint.Seconds())))
+	c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Server is shutting down"})
+	return true
+}
+
 // HandleLogin initiates OAuth2 login flow
 func (h *AuthHandler) HandleLogin(c *gin.Context) {
+	if h.rejectIfStopped(c) {
+		return
+	}
 	session := sessions.Default(c)
 
 	// Clear existing session
 	session.Clear()
 
 	state := h.authService.GenerateState()
-
-	log.Printf("Login: session ID = %s", session.ID())
-	log.Printf("Login: generated state = %s", state)
+	nonce := h.authService.GenerateNonce()
+	verifier := h.authService.GeneratePKCEVerifier()
 
 	session.Set("state", state)
+	session.Set("nonce", nonce)
+	session.Set("pkce_verifier", verifier)
 	if err := session.Save(); err != nil {
-		log.Printf("Login: session save error: %v", err)
+		slog.ErrorContext(c.Request.Context(), "login: session save failed", "error", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Session save failed"})
 		return
 	}
 
-	log.Printf("Login: state saved to session")
-	savedState := session.Get("state")
-	log.Printf("Login: verified saved state = %v", savedState)
-
-	authURL := h.authService.GetAuthURL(state)
-	log.Printf("Login: redirecting to %s", authURL)
+	authURL := h.authService.GetAuthURL(state, nonce, verifier)
+	slog.InfoContext(c.Request.Context(), "login: redirecting to IdP")
 
 	c.Redirect(http.StatusFound, authURL)
 }
 
 // HandleCallback handles OAuth2 callback
 func (h *AuthHandler) HandleCallback(c *gin.Context) {
+	if h.rejectIfStopped(c) {
+		return
+	}
 	session := sessions.Default(c)
 	storedState := session.Get("state")
 	receivedState := c.Query("state")
-
-	log.Printf("Callback: session ID = %s", session.ID())
-	log.Printf("Callback: stored state = %v", storedState)
-	log.Printf("Callback: received state = %s", receivedState)
+	ctx := c.Request.Context()
 
 	if storedState != receivedState {
-		log.Printf("State mismatch: stored='%v', received='%s'", storedState, receivedState)
+		slog.WarnContext(ctx, "callback: state mismatch")
+		metrics.CallbackFailures.WithLabelValues("invalid_state").Inc()
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid state"})
 		return
 	}
 
 	code := c.Query("code")
 	if code == "" {
+		metrics.CallbackFailures.WithLabelValues("missing_code").Inc()
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing code"})
 		return
 	}
 
-	ctx := context.Background()
-	token, err := h.authService.ExchangeCode(ctx, code)
+	nonce, _ := session.Get("nonce").(string)
+	verifier, _ := session.Get("pkce_verifier").(string)
+	if nonce == "" || verifier == "" {
+		slog.WarnContext(ctx, "callback: missing nonce or PKCE verifier in session")
+		metrics.CallbackFailures.WithLabelValues("invalid_login_state").Inc()
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid login state"})
+		return
+	}
+
+	exchangeStart := time.Now()
+	token, err := h.authService.ExchangeCode(ctx, code, verifier)
+	metrics.TokenExchangeDuration.Observe(time.Since(exchangeStart).Seconds())
 	if err != nil {
-		log.Printf("Token exchange error: %v", err)
+		slog.ErrorContext(ctx, "callback: code exchange failed", "error", err)
+		metrics.CallbackFailures.WithLabelValues("token_exchange").Inc()
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Token exchange failed"})
 		return
 	}
 
 	rawIDToken, ok := token.Extra("id_token").(string)
 	if !ok {
+		metrics.CallbackFailures.WithLabelValues("missing_id_token").Inc()
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Missing ID token"})
 		return
 	}
 
-	claims, err := h.authService.VerifyIDToken(ctx, rawIDToken)
+	claims, err := h.authService.VerifyIDToken(ctx, rawIDToken, nonce)
+	if errors.Is(err, services.ErrNonceMismatch) {
+		slog.WarnContext(ctx, "callback: ID token nonce mismatch")
+		metrics.CallbackFailures.WithLabelValues("nonce_mismatch").Inc()
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID token nonce mismatch"})
+		return
+	}
 	if err != nil {
-		log.Printf("ID token verification error: %v", err)
+		slog.ErrorContext(ctx, "callback: ID token verification failed", "error", err)
+		metrics.CallbackFailures.WithLabelValues("invalid_id_token").Inc()
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "ID token verification failed"})
 		return
 	}
@@ -106,103 +151,226 @@ func (h *AuthHandler) HandleCallback(c *gin.Context) {
 	// Extract user information
 	userID := claims["sub"].(string)
 	profile := h.authService.ExtractUserProfile(claims)
+	sid, _ := claims["sid"].(string)
 
 	// Create session data
+	now := time.Now()
 	sessionID := uuid.New().String()
 	sessionData := &models.SessionData{
-		SessionID: sessionID,
-		User:      profile,
-		LoginTime: time.Now(),
+		SessionID:    sessionID,
+		IdPSessionID: sid,
+		User:         profile,
+		LoginTime:    now,
+		LastSeen:     now,
+		ExpiresAt:    now.Add(h.config.SessionMaxLifetime),
+		Tokens:       services.NewTokenSet(token),
 	}
 
 	// Store in active sessions
-	h.sessionService.AddSession(userID, sessionData)
+	if err := h.sessionService.AddSession(sessionData); err != nil {
+		slog.ErrorContext(ctx, "callback: storing session failed", "error", err)
+		metrics.CallbackFailures.WithLabelValues("session_store").Inc()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Session save failed"})
+		return
+	}
 
-	// Save user ID to session
-	session.Set("user_id", userID)
+	// The cookie only references the server-side session
+	session.Delete("state")
+	session.Delete("nonce")
+	session.Delete("pkce_verifier")
+	session.Set("session_id", sessionID)
 	if err := session.Save(); err != nil {
-		log.Printf("Session save error: %v", err)
+		slog.ErrorContext(ctx, "callback: cookie session save failed", "error", err)
+		metrics.CallbackFailures.WithLabelValues("cookie_save").Inc()
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Session save failed"})
 		return
 	}
 
-	log.Printf("User logged in: %s", userID)
-	log.Printf("Session saved for user: %s", userID)
+	logging.SetUserID(ctx, userID)
+	slog.InfoContext(ctx, "user logged in", "session_id", sessionID)
+	metrics.Logins.Inc()
 
 	c.Redirect(http.StatusFound, h.config.FrontendURL)
 }
 
-// HandleLogout handles user logout
+// HandleLogout revokes the session's tokens at the IdP, ends the local
+// session and returns the Keycloak end-session URL the browser should visit
 func (h *AuthHandler) HandleLogout(c *gin.Context) {
 	session := sessions.Default(c)
-	userID := session.Get("user_id")
-
-	if userID != nil {
-		h.sessionService.RemoveSession(userID.(string))
-		h.sessionService.RemoveSSEClient(userID.(string))
-		log.Printf("User logged out: %s", userID)
+	reqCtx := c.Request.Context()
+
+	var idTokenHint string
+	revocation := []models.RevocationResult{}
+	if sessionID, ok := session.Get("session_id").(string); ok {
+		if sessionData, exists := h.sessionService.GetSession(reqCtx, sessionID); exists {
+			logging.SetUserID(reqCtx, sessionData.User.ID)
+			ctx, cancel := context.WithTimeout(reqCtx, 10*time.Second)
+			revocation = h.authService.RevokeTokens(ctx, sessionData.Tokens)
+			cancel()
+
+			for _, result := range revocation {
+				if !result.Revoked {
+					slog.WarnContext(reqCtx, "logout: revocation failed", "hint", result.TokenTypeHint, "session_id", sessionID, "error", result.Error)
+					if h.config.RevocationRequired {
+						c.JSON(http.StatusBadGateway, gin.H{
+							"error":      "Token revocation failed",
+							"revocation": revocation,
+						})
+						return
+					}
+				}
+			}
+
+			if sessionData.Tokens != nil {
+				idTokenHint = sessionData.Tokens.IDToken
+			}
+			h.sessionService.RemoveSessionByID(reqCtx, sessionID)
+			h.sessionService.RemoveSubscribersForSession(sessionID)
+			slog.InfoContext(reqCtx, "user logged out", "session_id", sessionID)
+		}
 	}
 
+	// The state comes back on /auth/logout/callback
+	state := h.authService.GenerateState()
 	session.Clear()
-	session.Save()
+	session.Set("logout_state", state)
+	if err := session.Save(); err != nil {
+		slog.ErrorContext(reqCtx, "logout: cookie session save failed", "error", err)
+	}
 
-	logoutURL := h.config.GetLogoutURL()
-	c.JSON(http.StatusOK, gin.H{"logoutUrl": logoutURL})
+	logoutURL := h.authService.EndSessionURL(idTokenHint, h.config.GetPostLogoutRedirectURL(), state)
+	c.JSON(http.StatusOK, gin.H{
+		"logoutUrl":  logoutURL,
+		"revocation": revocation,
+	})
+}
+
+// HandleLogoutCallback handles the redirect back from Keycloak after
+// RP-initiated logout
+func (h *AuthHandler) HandleLogoutCallback(c *gin.Context) {
+	session := sessions.Default(c)
+	storedState, _ := session.Get("logout_state").(string)
+	receivedState := c.Query("state")
+
+	if storedState == "" || subtle.ConstantTimeCompare([]byte(storedState), []byte(receivedState)) != 1 {
+		slog.WarnContext(c.Request.Context(), "logout callback: state mismatch")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid logout state"})
+		return
+	}
+
+	session.Delete("logout_state")
+	if err := session.Save(); err != nil {
+		slog.ErrorContext(c.Request.Context(), "logout callback: cookie session save failed", "error", err)
+	}
+
+	c.Redirect(http.StatusFound, h.config.FrontendURL)
 }
 
 // HandleBackchannelLogout handles Keycloak backchannel logout
 func (h *AuthHandler) HandleBackchannelLogout(c *gin.Context) {
-	log.Println("=== Backchannel Logout Called ===")
-	log.Printf("Request body: %+v", c.Request.Form)
-	log.Printf("Content-Type: %s", c.GetHeader("Content-Type"))
+	ctx := c.Request.Context()
 
 	logoutToken := c.PostForm("logout_token")
 	if logoutToken == "" {
-		log.Println("ERROR: Missing logout_token")
+		slog.WarnContext(ctx, "backchannel logout: missing logout_token", "content_type", c.ContentType())
+		metrics.BackchannelLogouts.WithLabelValues(metrics.OutcomeMissingToken).Inc()
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing logout_token"})
 		return
 	}
 
-	log.Printf("Logout token received: %s", logoutToken)
-
-	claims, err := h.authService.ParseLogoutToken(logoutToken)
+	token, err := h.authService.ParseLogoutToken(ctx, logoutToken)
 	if err != nil {
-		log.Printf("ERROR: %v", err)
+		slog.WarnContext(ctx, "backchannel logout: invalid logout token", "error", err)
+		var tokenErr *services.LogoutTokenError
+		if errors.As(err, &tokenErr) {
+			metrics.BackchannelLogouts.WithLabelValues(tokenErr.Code).Inc()
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid logout token", "code": tokenErr.Code})
+			return
+		}
+		metrics.BackchannelLogouts.WithLabelValues(metrics.OutcomeInvalidToken).Inc()
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid logout token"})
 		return
 	}
 
-	log.Printf("Decoded payload: %+v", claims)
-
-	userID, ok := claims["sub"].(string)
-	if !ok {
-		log.Println("ERROR: Missing 'sub' claim in logout token")
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid logout token: missing sub"})
-		return
+	logging.SetUserID(ctx, token.Subject)
+
+	// The sessions may live on any replica; EndSessions ends the local ones
+	// and forwards the target to the others
+	removed := h.sessionService.EndSessions(services.LogoutTarget{
+		Subject: token.Subject,
+		SID:     token.SessionID,
+		Reason:  services.ReasonBackchannelLogout,
+	})
+	for _, session := range removed {
+		slog.InfoContext(ctx, "backchannel logout: session invalidated", "session_id", session.SessionID, "sid", session.IdPSessionID)
+	}
+	if len(removed) == 0 {
+		slog.InfoContext(ctx, "backchannel logout: no local session matched, forwarded to other replicas", "sid", token.SessionID)
+		metrics.BackchannelLogouts.WithLabelValues(metrics.OutcomeNoSession).Inc()
+	} else {
+		metrics.BackchannelLogouts.WithLabelValues(metrics.OutcomeLoggedOut).Inc()
 	}
 
-	log.Printf("Extracted user ID: %s", userID)
-
-	// Check and remove active session
-	sessions := h.sessionService.GetAllSessions()
-	log.Printf("Active sessions before: %+v", sessions)
+	c.JSON(http.StatusOK, gin.H{"status": "success"})
+}
 
-	if _, exists := h.sessionService.GetSession(userID); exists {
-		h.sessionService.RemoveSession(userID)
-		h.sessionService.NotifySessionInvalidated(userID)
-		log.Printf("✅ User session invalidated: %s", userID)
-	} else {
-		log.Printf("⚠ User session not found in active sessions: %s", userID)
+// HandleFrontchannelLogout handles OIDC Front-Channel Logout, where Keycloak
+// loads this URL in an iframe with the iss and sid of the ended session. The
+// request is unauthenticated, so only the caller's own cookie session is
+// ended, and with a sid only if that session is bound to it.
+func (h *AuthHandler) HandleFrontchannelLogout(c *gin.Context) {
+	// The response must never be served from a cache
+	c.Header("Cache-Control", "no-cache, no-store")
+	c.Header("Pragma", "no-cache")
+
+	iss := c.Query("iss")
+	sid := c.Query("sid")
+	ctx := c.Request.Context()
+
+	var removed []*models.SessionData
+	switch {
+	case iss == "" && sid == "":
+		// Without iss/sid the IdP relies on the browser sending our cookie
+		session := sessions.Default(c)
+		if sessionID, ok := session.Get("session_id").(string); ok {
+			removed = h.sessionService.EndSessions(services.LogoutTarget{SessionID: sessionID, Reason: services.ReasonFrontchannelLogout})
+		}
+		session.Clear()
+		session.Save()
+	case iss != h.config.GetIssuerURL():
+		slog.WarnContext(ctx, "frontchannel logout: issuer mismatch", "iss", iss)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid issuer"})
+		return
+	case sid == "":
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing sid"})
+		return
+	default:
+		session := sessions.Default(c)
+		sessionID, ok := session.Get("session_id").(string)
+		if !ok {
+			slog.InfoContext(ctx, "frontchannel logout: no session cookie, ignoring", "sid", sid)
+			break
+		}
+		// The session may be held by another replica, which checks the sid
+		removed = h.sessionService.EndSessions(services.LogoutTarget{SessionID: sessionID, SID: sid, Reason: services.ReasonFrontchannelLogout})
+		if len(removed) > 0 {
+			session.Clear()
+			session.Save()
+		}
 	}
 
-	sessions = h.sessionService.GetAllSessions()
-	log.Printf("Active sessions after: %+v", sessions)
+	for _, session := range removed {
+		slog.InfoContext(ctx, "frontchannel logout: session invalidated", "target_user_id", session.User.ID, "session_id", session.SessionID, "sid", session.IdPSessionID)
+	}
+	if len(removed) == 0 {
+		slog.InfoContext(ctx, "frontchannel logout: no local session matched, forwarded to other replicas", "sid", sid)
+	}
 
-	c.JSON(http.StatusOK, gin.H{"status": "success"})
+	c.Data(http.StatusOK, "text/html; charset=utf-8", []byte("<!DOCTYPE html><html><body></body></html>"))
 }
 
 // HandleBackchannelLogoutTest handles test endpoint
 func (h *AuthHandler) HandleBackchannelLogoutTest(c *gin.Context) {
-	log.Println("=== Backchannel Logout Test (GET) Called ===")
+	slog.DebugContext(c.Request.Context(), "backchannel logout test endpoint called")
 	c.JSON(http.StatusOK, gin.H{"message": "Backchannel logout endpoint is working"})
 }
\ No newline at end of file
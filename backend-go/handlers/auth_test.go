@@ -0,0 +1,491 @@
+package handlers_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-contrib/sessions/cookie"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/oauth2"
+
+	"keycloak-logout-backend-go/config"
+	"keycloak-logout-backend-go/handlers"
+	"keycloak-logout-backend-go/models"
+	"keycloak-logout-backend-go/services"
+	"keycloak-logout-backend-go/services/oidctest"
+	"keycloak-logout-backend-go/services/storetest"
+)
+
+const testClientID = oidctest.ClientID
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// newSessionService creates a session service with a memory store on bus,
+// closed when the test ends
+func newSessionService(t *testing.T, bus services.EventBus) *services.SessionService {
+	t.Helper()
+	s := services.NewSessionService(services.NewMemorySessionStore(), bus, services.NotifyOptions{
+		QueueSize:         16,
+		DropPolicy:        services.DropOldest,
+		DispatchQueueSize: 16,
+	})
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		s.Close(ctx)
+	})
+	return s
+}
+
+// addSession adds a session of userID bound to sid
+func addSession(t *testing.T, s *services.SessionService, sessionID, userID, sid string) {
+	t.Helper()
+	if err := s.AddSession(storetest.NewSession(sessionID, userID, sid)); err != nil {
+		t.Fatalf("AddSession(%s): %v", sessionID, err)
+	}
+}
+
+// backchannelFixture wires the back-channel logout endpoint to a stand-in realm
+type backchannelFixture struct {
+	idp      *oidctest.Provider
+	sessions *services.SessionService
+	router   *gin.Engine
+}
+
+func newBackchannelFixture(t *testing.T) *backchannelFixture {
+	t.Helper()
+	idp := oidctest.NewProvider(t)
+	cfg := idp.Config(testClientID)
+	authService, err := services.NewAuthService(cfg, services.NewMemoryReplayCache())
+	if err != nil {
+		t.Fatalf("NewAuthService: %v", err)
+	}
+	sessionService := newSessionService(t, services.NewLocalEventBus())
+
+	r := gin.New()
+	r.POST("/auth/backchannel-logout", handlers.NewAuthHandler(cfg, authService, sessionService).HandleBackchannelLogout)
+	return &backchannelFixture{idp: idp, sessions: sessionService, router: r}
+}
+
+// post sends a logout token and returns the status and decoded JSON body
+func (f *backchannelFixture) post(t *testing.T, logoutToken string) (int, map[string]string) {
+	t.Helper()
+	form := url.Values{"logout_token": {logoutToken}}
+	req := httptest.NewRequest(http.MethodPost, "/auth/backchannel-logout", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	f.router.ServeHTTP(w, req)
+
+	var body map[string]string
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode response %q: %v", w.Body.String(), err)
+	}
+	return w.Code, body
+}
+
+func TestBackchannelLogoutValidToken(t *testing.T) {
+	f := newBackchannelFixture(t)
+	addSession(t, f.sessions, "session-1", "user-1", "sid-1")
+
+	status, body := f.post(t, f.idp.Sign(t, f.idp.LogoutClaims(testClientID, "user-1", "sid-1")))
+	if status != http.StatusOK {
+		t.Fatalf("status %d, body %v; want 200", status, body)
+	}
+	if _, exists := f.sessions.GetSession(context.Background(), "session-1"); exists {
+		t.Error("session still exists after back-channel logout")
+	}
+}
+
+func TestBackchannelLogoutBadSignature(t *testing.T) {
+	f := newBackchannelFixture(t)
+
+	status, body := f.post(t, f.idp.SignWithUnpublishedKey(t, f.idp.LogoutClaims(testClientID, "user-1", "sid-1")))
+	if status != http.StatusBadRequest {
+		t.Fatalf("status %d; want 400", status)
+	}
+	if body["code"] != "invalid_signature" {
+		t.Errorf("code %q; want invalid_signature", body["code"])
+	}
+}
+
+func TestBackchannelLogoutMissingToken(t *testing.T) {
+	f := newBackchannelFixture(t)
+
+	req := httptest.NewRequest(http.MethodPost, "/auth/backchannel-logout", strings.NewReader(""))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	f.router.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("status %d; want 400", w.Code)
+	}
+}
+
+// frontchannelFixture serves the front-channel logout endpoint with cookie
+// sessions, plus a route that binds the cookie to a session ID
+type frontchannelFixture struct {
+	idp      *oidctest.Provider
+	sessions *services.SessionService
+	router   *gin.Engine
+}
+
+func newFrontchannelFixture(t *testing.T) *frontchannelFixture {
+	t.Helper()
+	idp := oidctest.NewProvider(t)
+	cfg := idp.Config(testClientID)
+	authService, err := services.NewAuthService(cfg, services.NewMemoryReplayCache())
+	if err != nil {
+		t.Fatalf("NewAuthService: %v", err)
+	}
+	sessionService := newSessionService(t, services.NewLocalEventBus())
+
+	r := gin.New()
+	r.Use(sessions.Sessions("keycloak-session", cookie.NewStore([]byte("test-secret"))))
+	r.GET("/test/session/:id", func(c *gin.Context) {
+		session := sessions.Default(c)
+		session.Set("session_id", c.Param("id"))
+		session.Save()
+	})
+	r.GET("/auth/frontchannel-logout", handlers.NewAuthHandler(cfg, authService, sessionService).HandleFrontchannelLogout)
+	return &frontchannelFixture{idp: idp, sessions: sessionService, router: r}
+}
+
+// cookieFor returns a session cookie bound to sessionID
+func (f *frontchannelFixture) cookieFor(t *testing.T, sessionID string) *http.Cookie {
+	t.Helper()
+	w := httptest.NewRecorder()
+	f.router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/test/session/"+sessionID, nil))
+	cookies := w.Result().Cookies()
+	if len(cookies) == 0 {
+		t.Fatal("no session cookie set")
+	}
+	return cookies[0]
+}
+
+// logout calls the front-channel logout endpoint with sid and an optional
+// session cookie
+func (f *frontchannelFixture) logout(t *testing.T, sid string, cookie *http.Cookie) int {
+	t.Helper()
+	query := url.Values{"iss": {f.idp.Issuer()}, "sid": {sid}}
+	req := httptest.NewRequest(http.MethodGet, "/auth/frontchannel-logout?"+query.Encode(), nil)
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+	w := httptest.NewRecorder()
+	f.router.ServeHTTP(w, req)
+	return w.Code
+}
+
+func TestFrontchannelLogout(t *testing.T) {
+	tests := []struct {
+		name       string
+		cookie     string // session the caller's cookie points to, if any
+		sid        string
+		wantEnded  []string
+		wantActive []string
+	}{
+		{"own session bound to sid", "mine", "sid-mine", []string{"mine"}, []string{"victim"}},
+		{"sid of another session", "mine", "sid-victim", nil, []string{"mine", "victim"}},
+		{"sid without session cookie", "", "sid-victim", nil, []string{"mine", "victim"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFrontchannelFixture(t)
+			addSession(t, f.sessions, "mine", "user-1", "sid-mine")
+			addSession(t, f.sessions, "victim", "user-2", "sid-victim")
+
+			var cookie *http.Cookie
+			if tt.cookie != "" {
+				cookie = f.cookieFor(t, tt.cookie)
+			}
+			if status := f.logout(t, tt.sid, cookie); status != http.StatusOK {
+				t.Fatalf("status %d; want 200", status)
+			}
+
+			for _, id := range tt.wantEnded {
+				if _, exists := f.sessions.GetSession(context.Background(), id); exists {
+					t.Errorf("session %s still exists", id)
+				}
+			}
+			for _, id := range tt.wantActive {
+				if _, exists := f.sessions.GetSession(context.Background(), id); !exists {
+					t.Errorf("session %s was ended", id)
+				}
+			}
+		})
+	}
+}
+
+func TestFrontchannelLogoutIssuerMismatch(t *testing.T) {
+	f := newFrontchannelFixture(t)
+	addSession(t, f.sessions, "mine", "user-1", "sid-mine")
+
+	req := httptest.NewRequest(http.MethodGet, "/auth/frontchannel-logout?iss=https://evil.example&sid=sid-mine", nil)
+	req.AddCookie(f.cookieFor(t, "mine"))
+	w := httptest.NewRecorder()
+	f.router.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("status %d; want 400", w.Code)
+	}
+	if _, exists := f.sessions.GetSession(context.Background(), "mine"); !exists {
+		t.Error("session ended despite issuer mismatch")
+	}
+}
+
+// loginFixture serves the login, logout and their callback endpoints with
+// cookie sessions
+type loginFixture struct {
+	idp      *oidctest.Provider
+	cfg      *config.Config
+	sessions *services.SessionService
+	router   *gin.Engine
+}
+
+func newLoginFixture(t *testing.T) *loginFixture {
+	t.Helper()
+	idp := oidctest.NewProvider(t)
+	cfg := idp.Config(testClientID)
+	cfg.SessionMaxLifetime = time.Hour
+	authService, err := services.NewAuthService(cfg, services.NewMemoryReplayCache())
+	if err != nil {
+		t.Fatalf("NewAuthService: %v", err)
+	}
+	sessionService := newSessionService(t, services.NewLocalEventBus())
+	h := handlers.NewAuthHandler(cfg, authService, sessionService)
+
+	r := gin.New()
+	r.Use(sessions.Sessions("keycloak-session", cookie.NewStore([]byte("test-secret"))))
+	r.GET("/auth/login", h.HandleLogin)
+	r.GET("/auth/callback", h.HandleCallback)
+	r.GET("/auth/logout", h.HandleLogout)
+	r.GET("/auth/logout/callback", h.HandleLogoutCallback)
+	return &loginFixture{idp: idp, cfg: cfg, sessions: sessionService, router: r}
+}
+
+// login starts a login and returns the authorization URL and the cookie
+// holding the login state
+func (f *loginFixture) login(t *testing.T) (*url.URL, *http.Cookie) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	f.router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/auth/login", nil))
+	if w.Code != http.StatusFound {
+		t.Fatalf("login status %d; want 302", w.Code)
+	}
+	authURL, err := url.Parse(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatalf("parse authorization URL: %v", err)
+	}
+	cookies := w.Result().Cookies()
+	if len(cookies) == 0 {
+		t.Fatal("no session cookie set")
+	}
+	return authURL, cookies[0]
+}
+
+// callback returns to the callback endpoint with the IdP's code and state
+func (f *loginFixture) callback(t *testing.T, code, state string, cookie *http.Cookie) *httptest.ResponseRecorder {
+	t.Helper()
+	query := url.Values{"code": {code}, "state": {state}}
+	req := httptest.NewRequest(http.MethodGet, "/auth/callback?"+query.Encode(), nil)
+	req.AddCookie(cookie)
+	w := httptest.NewRecorder()
+	f.router.ServeHTTP(w, req)
+	return w
+}
+
+func TestCallback(t *testing.T) {
+	tests := []struct {
+		name   string
+		tamper func(query url.Values) // changes the authorization request the IdP sees
+		status int
+	}{
+		{"valid login", func(url.Values) {}, http.StatusFound},
+		{"nonce mismatch", func(query url.Values) { query.Set("nonce", "other-nonce") }, http.StatusBadRequest},
+		{"PKCE verifier mismatch", func(query url.Values) {
+			query.Set("code_challenge", oauth2.S256ChallengeFromVerifier(oauth2.GenerateVerifier()))
+		}, http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newLoginFixture(t)
+			authURL, cookie := f.login(t)
+			query := authURL.Query()
+			state := query.Get("state")
+			tt.tamper(query)
+			authURL.RawQuery = query.Encode()
+
+			code := f.idp.Authorize(t, authURL.String(), "user-1", "sid-1")
+			w := f.callback(t, code, state, cookie)
+			if w.Code != tt.status {
+				t.Fatalf("status %d, body %q; want %d", w.Code, w.Body.String(), tt.status)
+			}
+
+			sessions := f.sessions.GetAllSessions(context.Background())
+			if tt.status != http.StatusFound {
+				if len(sessions) != 0 {
+					t.Errorf("%d sessions created by a rejected callback", len(sessions))
+				}
+				return
+			}
+			if len(sessions) != 1 || sessions[0].User.ID != "user-1" || sessions[0].IdPSessionID != "sid-1" {
+				t.Errorf("sessions %+v; want one of user-1 bound to sid-1", sessions)
+			}
+		})
+	}
+}
+
+func TestCallbackStateMismatch(t *testing.T) {
+	f := newLoginFixture(t)
+	authURL, cookie := f.login(t)
+
+	code := f.idp.Authorize(t, authURL.String(), "user-1", "sid-1")
+	if w := f.callback(t, code, "forged-state", cookie); w.Code != http.StatusBadRequest {
+		t.Errorf("status %d; want 400", w.Code)
+	}
+}
+
+// signIn completes a login of user-1 and returns the session cookie
+func (f *loginFixture) signIn(t *testing.T) *http.Cookie {
+	t.Helper()
+	authURL, cookie := f.login(t)
+	code := f.idp.Authorize(t, authURL.String(), "user-1", "sid-1")
+	w := f.callback(t, code, authURL.Query().Get("state"), cookie)
+	if w.Code != http.StatusFound {
+		t.Fatalf("callback status %d, body %q; want 302", w.Code, w.Body.String())
+	}
+	return w.Result().Cookies()[0]
+}
+
+// logoutRequest calls the logout endpoint with an optional session cookie
+func (f *loginFixture) logoutRequest(t *testing.T, cookie *http.Cookie) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/auth/logout", nil)
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+	w := httptest.NewRecorder()
+	f.router.ServeHTTP(w, req)
+	return w
+}
+
+// logout starts an RP-initiated logout and returns the end-session URL and
+// the cookie holding the logout state
+func (f *loginFixture) logout(t *testing.T) (*url.URL, *http.Cookie) {
+	t.Helper()
+	w := f.logoutRequest(t, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("logout status %d; want 200", w.Code)
+	}
+	var body struct {
+		LogoutURL string `json:"logoutUrl"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode logout response: %v", err)
+	}
+	logoutURL, err := url.Parse(body.LogoutURL)
+	if err != nil {
+		t.Fatalf("parse logout URL: %v", err)
+	}
+	cookies := w.Result().Cookies()
+	if len(cookies) == 0 {
+		t.Fatal("no session cookie set")
+	}
+	return logoutURL, cookies[0]
+}
+
+// logoutCallback returns to the logout callback endpoint with state
+func (f *loginFixture) logoutCallback(t *testing.T, state string, cookie *http.Cookie) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/auth/logout/callback?state="+url.QueryEscape(state), nil)
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+	w := httptest.NewRecorder()
+	f.router.ServeHTTP(w, req)
+	return w
+}
+
+func TestLogoutCallbackState(t *testing.T) {
+	f := newLoginFixture(t)
+	logoutURL, cookie := f.logout(t)
+	state := logoutURL.Query().Get("state")
+	if state == "" {
+		t.Fatalf("logout URL %s has no state", logoutURL)
+	}
+
+	if w := f.logoutCallback(t, "forged-state", cookie); w.Code != http.StatusBadRequest {
+		t.Errorf("forged state: status %d; want 400", w.Code)
+	}
+	if w := f.logoutCallback(t, state, nil); w.Code != http.StatusBadRequest {
+		t.Errorf("state without cookie: status %d; want 400", w.Code)
+	}
+
+	w := f.logoutCallback(t, state, cookie)
+	if w.Code != http.StatusFound || w.Header().Get("Location") != "http://localhost:3000" {
+		t.Fatalf("status %d, location %q; want a redirect to the frontend", w.Code, w.Header().Get("Location"))
+	}
+	// The state is used up, so the callback cannot be replayed with the
+	// updated cookie
+	if w := f.logoutCallback(t, state, w.Result().Cookies()[0]); w.Code != http.StatusBadRequest {
+		t.Errorf("reused state: status %d; want 400", w.Code)
+	}
+}
+
+func TestLogoutRevocation(t *testing.T) {
+	tests := []struct {
+		name         string
+		required     bool
+		fail         bool
+		status       int
+		sessionEnded bool
+	}{
+		{"tokens revoked", true, false, http.StatusOK, true},
+		{"failure tolerated", false, true, http.StatusOK, true},
+		{"failure with revocation required", true, true, http.StatusBadGateway, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newLoginFixture(t)
+			f.cfg.RevocationRequired = tt.required
+			cookie := f.signIn(t)
+			if tt.fail {
+				f.idp.FailRevocations(http.StatusServiceUnavailable, "unavailable")
+			}
+
+			w := f.logoutRequest(t, cookie)
+			if w.Code != tt.status {
+				t.Fatalf("status %d, body %q; want %d", w.Code, w.Body.String(), tt.status)
+			}
+			var body struct {
+				Revocation []models.RevocationResult `json:"revocation"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatalf("decode response: %v", err)
+			}
+			if len(body.Revocation) != 2 {
+				t.Fatalf("revocation %+v; want results for both tokens", body.Revocation)
+			}
+			for _, result := range body.Revocation {
+				if result.Revoked == tt.fail {
+					t.Errorf("%s: revoked %v; want %v", result.TokenTypeHint, result.Revoked, !tt.fail)
+				}
+			}
+
+			ended := len(f.sessions.GetAllSessions(context.Background())) == 0
+			if ended != tt.sessionEnded {
+				t.Errorf("session ended %v; want %v", ended, tt.sessionEnded)
+			}
+		})
+	}
+}
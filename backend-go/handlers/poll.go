@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"keycloak-logout-backend-go/models"
+	"keycloak-logout-backend-go/services"
+)
+
+// Long-poll wait limits
+const (
+	pollDefaultTimeout = 30 * time.Second
+	pollMaxTimeout     = 60 * time.Second
+)
+
+// pollResponse is the body of a long-poll response. LastEventID is the
+// value to pass as since on the next poll.
+type pollResponse struct {
+	Events      []models.SessionEvent `json:"events"`
+	LastEventID string                `json:"lastEventId"`
+}
+
+// HandlePollReplay returns the events a long-polling client missed when its
+// session has ended, since authentication would reject the poll. Otherwise
+// the request continues to authentication and HandlePoll.
+func (h *APIHandler) HandlePollReplay(c *gin.Context) {
+	since := c.Query("since")
+	missed := h.endedSessionEvents(c, since)
+	if len(missed) == 0 {
+		return
+	}
+	c.JSON(http.StatusOK, newPollResponse(missed, since))
+	c.Abort()
+}
+
+// HandlePoll implements GET /api/events/poll?since=<eventId>&timeout=30s for
+// clients that can use neither SSE nor WebSocket. Logged events after since
+// are returned at once; otherwise the request waits for the next session
+// event until the timeout (at most 60s) passes and then returns no events.
+func (h *APIHandler) HandlePoll(c *gin.Context) {
+	userID := c.GetString("user_id")
+	sessionID := c.GetString("session_id")
+	since := c.Query("since")
+	ctx := c.Request.Context()
+
+	timeout := pollDefaultTimeout
+	if value := c.Query("timeout"); value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil || d < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid timeout"})
+			return
+		}
+		timeout = min(d, pollMaxTimeout)
+	}
+
+	// Subscribe before reading the log so that no event falls between the two
+	sub := h.sessionService.Subscribe(services.TransportPoll, userID, sessionID)
+	defer h.sessionService.RemoveSubscriber(sub.ID())
+
+	if since != "" {
+		if missed := h.sessionService.EventsSince(sessionID, since); len(missed) > 0 {
+			c.JSON(http.StatusOK, newPollResponse(missed, since))
+			return
+		}
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-sub.Ready():
+		events := sub.Drain()
+		slog.DebugContext(ctx, "long poll returning events", "events", len(events))
+		c.JSON(http.StatusOK, newPollResponse(events, since))
+	case <-timer.C:
+		c.JSON(http.StatusOK, newPollResponse(nil, since))
+	case <-sub.Done():
+		c.JSON(http.StatusOK, newPollResponse(nil, since))
+	case <-ctx.Done():
+	}
+}
+
+// newPollResponse builds a poll response, advancing the last event ID past
+// the returned events. Events that are not logged, such as server_shutdown,
+// have no ID and leave the cursor where it was.
+func newPollResponse(events []models.SessionEvent, since string) pollResponse {
+	if events == nil {
+		events = []models.SessionEvent{}
+	}
+	lastEventID := since
+	for i := len(events) - 1; i >= 0; i-- {
+		if events[i].ID != "" {
+			lastEventID = events[i].ID
+			break
+		}
+	}
+	return pollResponse{Events: events, LastEventID: lastEventID}
+}
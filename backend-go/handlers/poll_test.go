@@ -0,0 +1,35 @@
+package handlers
+
+import (
+	"testing"
+
+	"keycloak-logout-backend-go/models"
+	"keycloak-logout-backend-go/services"
+)
+
+func TestNewPollResponseCursor(t *testing.T) {
+	tests := []struct {
+		name   string
+		events []models.SessionEvent
+		since  string
+		want   string
+	}{
+		{"no events keeps since", nil, "e1", "e1"},
+		{"advances to last event", []models.SessionEvent{{ID: "e2"}, {ID: "e3"}}, "e1", "e3"},
+		{"shutdown alone keeps since", []models.SessionEvent{{Type: services.EventServerShutdown}}, "e1", "e1"},
+		{"shutdown after logged event", []models.SessionEvent{{ID: "e2"}, {Type: services.EventServerShutdown}}, "e1", "e2"},
+		{"shutdown on first poll", []models.SessionEvent{{Type: services.EventServerShutdown}}, "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := newPollResponse(tt.events, tt.since)
+			if resp.LastEventID != tt.want {
+				t.Errorf("lastEventId %q; want %q", resp.LastEventID, tt.want)
+			}
+			if resp.Events == nil {
+				t.Error("events is nil; want an empty list")
+			}
+		})
+	}
+}
@@ -0,0 +1,226 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+
+	"keycloak-logout-backend-go/config"
+	"keycloak-logout-backend-go/models"
+	"keycloak-logout-backend-go/services"
+)
+
+// WebSocket connection limits
+const (
+	wsWriteWait      = 10 * time.Second
+	wsPongWait       = 60 * time.Second
+	wsPingPeriod     = wsPongWait * 9 / 10
+	wsMaxMessageSize = 512
+
+	// Unacknowledged session_invalidated events are resent after
+	// wsAckTimeout, at most wsMaxSends times in total
+	wsAckTimeout = 5 * time.Second
+	wsMaxSends   = 3
+)
+
+// wsClientMessage is a message from the browser. The only type is "ack",
+// acknowledging the event with the given ID.
+type wsClientMessage struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+// pendingAck is a sent event waiting for the client's acknowledgement
+type pendingAck struct {
+	event    models.SessionEvent
+	sentAt   time.Time
+	attempts int
+}
+
+// WebSocketHandler delivers session events over WebSocket, for clients
+// behind proxies that buffer SSE responses
+type WebSocketHandler struct {
+	sessionService *services.SessionService
+	upgrader       websocket.Upgrader
+	ackTimeout     time.Duration
+}
+
+// NewWebSocketHandler creates a new WebSocket handler. Upgrades are only
+// accepted from the frontend origin or the backend's own origin.
+func NewWebSocketHandler(cfg *config.Config, sessionSvc *services.SessionService) *WebSocketHandler {
+	return &WebSocketHandler{
+		sessionService: sessionSvc,
+		upgrader: websocket.Upgrader{
+			CheckOrigin: func(r *http.Request) bool {
+				origin := r.Header.Get("Origin")
+				return origin == "" || origin == cfg.FrontendURL ||
+					origin == "http://"+r.Host || origin == "https://"+r.Host
+			},
+		},
+		ackTimeout: wsAckTimeout,
+	}
+}
+
+// HandleWebSocket streams the session's events as JSON messages, the same
+// events SSE clients receive. Events after the lastEventId query parameter
+// are replayed first. The client acknowledges session_invalidated with
+// {"type":"ack","id":"<event id>"}; unacknowledged ones are resent, and the
+// connection is closed once the invalidation is acknowledged.
+func (h *WebSocketHandler) HandleWebSocket(c *gin.Context) {
+	userID := c.GetString("user_id")
+	sessionID := c.GetString("session_id")
+	ctx := c.Request.Context()
+
+	conn, err := h.upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		// The upgrader has already written the error response
+		slog.WarnContext(ctx, "websocket upgrade failed", "error", err)
+		return
+	}
+	defer conn.Close()
+
+	sub := h.sessionService.Subscribe(services.TransportWebSocket, userID, sessionID)
+	defer h.sessionService.RemoveSubscriber(sub.ID())
+	slog.InfoContext(ctx, "websocket client connected", "session_id", sessionID, "subscriber_id", sub.ID())
+
+	stop := make(chan struct{})
+	defer close(stop)
+	acks := make(chan string)
+	readDone := make(chan struct{})
+	go wsReadLoop(ctx, conn, acks, stop, readDone)
+
+	pending := make(map[string]*pendingAck)
+	send := func(event models.SessionEvent) error {
+		if event.Type == services.EventSessionInvalidated {
+			if p, ok := pending[event.ID]; ok {
+				p.attempts++
+				p.sentAt = time.Now()
+			} else {
+				pending[event.ID] = &pendingAck{event: event, sentAt: time.Now(), attempts: 1}
+			}
+		}
+		conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+		return conn.WriteJSON(event)
+	}
+
+	conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+	if err := conn.WriteJSON(models.SessionEvent{Type: "connected", SessionID: sessionID, Timestamp: time.Now()}); err != nil {
+		return
+	}
+
+	// The subscriber is registered before replaying, so an event may arrive
+	// both ways; replayed IDs are skipped on the live stream
+	replayed := make(map[string]bool)
+	if lastEventID := c.Query("lastEventId"); lastEventID != "" {
+		for _, event := range h.sessionService.EventsSince(sessionID, lastEventID) {
+			if err := send(event); err != nil {
+				return
+			}
+			replayed[event.ID] = true
+		}
+	}
+
+	ping := time.NewTicker(wsPingPeriod)
+	defer ping.Stop()
+	resend := time.NewTicker(h.ackTimeout)
+	defer resend.Stop()
+
+	for {
+		select {
+		case <-sub.Ready():
+			for _, event := range sub.Drain() {
+				if replayed[event.ID] {
+					continue
+				}
+				if err := send(event); err != nil {
+					slog.InfoContext(ctx, "websocket write failed", "subscriber_id", sub.ID(), "error", err)
+					return
+				}
+				if event.Type == services.EventServerShutdown {
+					slog.InfoContext(ctx, "websocket client told to reconnect, server shutting down", "subscriber_id", sub.ID())
+					wsClose(conn, websocket.CloseServiceRestart, "server shutting down")
+					return
+				}
+			}
+		case id := <-acks:
+			p, ok := pending[id]
+			if !ok {
+				continue
+			}
+			delete(pending, id)
+			slog.InfoContext(ctx, "websocket client acknowledged event", "subscriber_id", sub.ID(), "type", p.event.Type, "event_id", id)
+			if p.event.Type == services.EventSessionInvalidated {
+				wsClose(conn, websocket.CloseNormalClosure, "session ended")
+				return
+			}
+		case now := <-resend.C:
+			for id, p := range pending {
+				if now.Sub(p.sentAt) < h.ackTimeout {
+					continue
+				}
+				if p.attempts >= wsMaxSends {
+					slog.WarnContext(ctx, "websocket client never acknowledged event", "subscriber_id", sub.ID(), "type", p.event.Type, "event_id", id)
+					delete(pending, id)
+					continue
+				}
+				if err := send(p.event); err != nil {
+					return
+				}
+			}
+		case <-ping.C:
+			conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		case <-sub.Done():
+			slog.InfoContext(ctx, "websocket client disconnected by server", "subscriber_id", sub.ID())
+			wsClose(conn, websocket.CloseNormalClosure, "session ended")
+			return
+		case <-readDone:
+			slog.InfoContext(ctx, "websocket client connection closed", "subscriber_id", sub.ID())
+			return
+		}
+	}
+}
+
+// wsReadLoop reads client messages until the connection fails or the peer
+// stops answering pings, forwarding acknowledged event IDs to acks
+func wsReadLoop(ctx context.Context, conn *websocket.Conn, acks chan<- string, stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+
+	conn.SetReadLimit(wsMaxMessageSize)
+	conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		conn.SetReadDeadline(time.Now().Add(wsPongWait))
+
+		var msg wsClientMessage
+		if err := json.Unmarshal(data, &msg); err != nil || msg.Type != "ack" || msg.ID == "" {
+			slog.DebugContext(ctx, "ignoring websocket client message")
+			continue
+		}
+		select {
+		case acks <- msg.ID:
+		case <-stop:
+			return
+		}
+	}
+}
+
+// wsClose sends a close frame, ignoring errors since the connection is
+// being torn down anyway
+func wsClose(conn *websocket.Conn, code int, reason string) {
+	conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(wsWriteWait))
+}
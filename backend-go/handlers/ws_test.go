@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+
+	"keycloak-logout-backend-go/config"
+	"keycloak-logout-backend-go/models"
+	"keycloak-logout-backend-go/services"
+)
+
+const testAckTimeout = 50 * time.Millisecond
+
+// wsFixture serves the WebSocket endpoint for session s1 of user-1, with
+// events published straight on the local bus
+type wsFixture struct {
+	bus  *services.LocalEventBus
+	conn *websocket.Conn
+}
+
+func newWSFixture(t *testing.T) *wsFixture {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	bus := services.NewLocalEventBus()
+	sessionService := services.NewSessionService(services.NewMemorySessionStore(), bus, services.NotifyOptions{
+		QueueSize:         16,
+		DropPolicy:        services.DropOldest,
+		DispatchQueueSize: 16,
+	})
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		sessionService.Close(ctx)
+	})
+
+	h := NewWebSocketHandler(&config.Config{}, sessionService)
+	h.ackTimeout = testAckTimeout
+	r := gin.New()
+	r.GET("/api/ws", func(c *gin.Context) {
+		c.Set("user_id", "user-1")
+		c.Set("session_id", "s1")
+	}, h.HandleWebSocket)
+	server := httptest.NewServer(r)
+	t.Cleanup(server.Close)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/api/ws", nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	f := &wsFixture{bus: bus, conn: conn}
+	if event := f.read(t); event.Type != "connected" {
+		t.Fatalf("first message %q; want connected", event.Type)
+	}
+	return f
+}
+
+// read returns the next event sent to the client
+func (f *wsFixture) read(t *testing.T) models.SessionEvent {
+	t.Helper()
+	f.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var event models.SessionEvent
+	if err := f.conn.ReadJSON(&event); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	return event
+}
+
+// invalidate publishes a session_invalidated event for s1
+func (f *wsFixture) invalidate(t *testing.T, id string) {
+	t.Helper()
+	err := f.bus.Publish(context.Background(), services.BusEvent{
+		UserID: "user-1",
+		SessionEvent: models.SessionEvent{
+			ID:        id,
+			Type:      services.EventSessionInvalidated,
+			SessionID: "s1",
+			Timestamp: time.Now(),
+		},
+	})
+	if err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+}
+
+func TestWebSocketResendsUnacknowledgedEvents(t *testing.T) {
+	f := newWSFixture(t)
+	f.invalidate(t, "101")
+
+	// The event is sent wsMaxSends times and then given up on
+	sends := 0
+	f.conn.SetReadDeadline(time.Now().Add(20 * testAckTimeout))
+	for {
+		var event models.SessionEvent
+		if err := f.conn.ReadJSON(&event); err != nil {
+			var netErr interface{ Timeout() bool }
+			if !errors.As(err, &netErr) || !netErr.Timeout() {
+				t.Fatalf("read: %v", err)
+			}
+			break
+		}
+		if event.ID != "101" {
+			t.Fatalf("received event %q; want only 101", event.ID)
+		}
+		sends++
+	}
+	if sends != wsMaxSends {
+		t.Errorf("event sent %d times; want %d", sends, wsMaxSends)
+	}
+}
+
+func TestWebSocketAcknowledgedEventIsNotResent(t *testing.T) {
+	f := newWSFixture(t)
+	f.invalidate(t, "101")
+	if event := f.read(t); event.ID != "101" {
+		t.Fatalf("received event %q; want 101", event.ID)
+	}
+
+	if err := f.conn.WriteJSON(wsClientMessage{Type: "ack", ID: "101"}); err != nil {
+		t.Fatalf("ack: %v", err)
+	}
+
+	// Acknowledging the invalidation ends the connection instead of a resend
+	f.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var event models.SessionEvent
+	err := f.conn.ReadJSON(&event)
+	if !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+		t.Errorf("got event %+v, error %v; want a normal close", event, err)
+	}
+}
@@ -0,0 +1,106 @@
+package logging
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+
+	"keycloak-logout-backend-go/config"
+)
+
+// New builds the application logger from the configured level and format.
+// Output passes through the redaction layer and, for request contexts,
+// carries the request and user ID.
+func New(cfg *config.Config, w io.Writer) *slog.Logger {
+	opts := &slog.HandlerOptions{
+		Level:       ParseLevel(cfg.LogLevel),
+		ReplaceAttr: Redact,
+	}
+
+	var handler slog.Handler
+	if strings.EqualFold(cfg.LogFormat, "json") {
+		handler = slog.NewJSONHandler(w, opts)
+	} else {
+		handler = slog.NewTextHandler(w, opts)
+	}
+	return slog.New(&contextHandler{next: handler})
+}
+
+// ParseLevel maps a LOG_LEVEL value to a slog level, defaulting to info
+func ParseLevel(level string) slog.Level {
+	switch strings.ToLower(strings.TrimSpace(level)) {
+	case "debug":
+		return slog.LevelDebug
+	case "warn", "warning":
+		return slog.LevelWarn
+	case "error":
+		return slog.LevelError
+	default:
+		return slog.LevelInfo
+	}
+}
+
+// RequestInfo identifies the request a log line belongs to. The user ID is
+// filled in by the authentication middleware once it is known.
+type RequestInfo struct {
+	mu        sync.RWMutex
+	requestID string
+	userID    string
+}
+
+type requestInfoKey struct{}
+
+// WithRequest returns a context that tags log records with requestID
+func WithRequest(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestInfoKey{}, &RequestInfo{requestID: requestID})
+}
+
+// SetUserID records the authenticated user for the request in ctx
+func SetUserID(ctx context.Context, userID string) {
+	if info, ok := ctx.Value(requestInfoKey{}).(*RequestInfo); ok {
+		info.mu.Lock()
+		info.userID = userID
+		info.mu.Unlock()
+	}
+}
+
+// RequestID returns the request ID carried by ctx, if any
+func RequestID(ctx context.Context) string {
+	if info, ok := ctx.Value(requestInfoKey{}).(*RequestInfo); ok {
+		info.mu.RLock()
+		defer info.mu.RUnlock()
+		return info.requestID
+	}
+	return ""
+}
+
+// contextHandler adds request_id and user_id to records logged with a
+// request context.
+type contextHandler struct {
+	next slog.Handler
+}
+
+func (h *contextHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h *contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if ctx != nil {
+		if info, ok := ctx.Value(requestInfoKey{}).(*RequestInfo); ok {
+			info.mu.RLock()
+			r.AddAttrs(slog.String("request_id", info.requestID), slog.String("user_id", info.userID))
+			info.mu.RUnlock()
+		}
+	}
+	return h.next.Handle(ctx, r)
+}
+
+func (h *contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &contextHandler{next: h.next.WithAttrs(attrs)}
+}
+
+func (h *contextHandler) WithGroup(name string) slog.Handler {
+	return &contextHandler{next: h.next.WithGroup(name)}
+}
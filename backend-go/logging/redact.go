@@ -0,0 +1,80 @@
+package logging
+
+import (
+	"log/slog"
+	"regexp"
+	"strings"
+)
+
+const redacted = "[REDACTED]"
+
+// Attribute keys whose values are never logged. Matching is by substring
+// on the lower-cased key, so "access_token" and "client_secret" are covered.
+var sensitiveKeys = []string{
+	"token",
+	"secret",
+	"password",
+	"authorization",
+	"cookie",
+	"verifier",
+	"nonce",
+}
+
+var (
+	jwtPattern    = regexp.MustCompile(`eyJ[A-Za-z0-9_-]*\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`)
+	bearerPattern = regexp.MustCompile(`(?i)bearer\s+[A-Za-z0-9._~+/=-]+`)
+	emailPattern  = regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`)
+)
+
+// Redact is a slog ReplaceAttr function that masks tokens, secrets and
+// email addresses, both under sensitive keys and inside free-form values
+// such as messages and error strings.
+func Redact(groups []string, a slog.Attr) slog.Attr {
+	if a.Value.Kind() == slog.KindGroup {
+		return a
+	}
+
+	key := strings.ToLower(a.Key)
+	if key == "state" || isSensitiveKey(key) {
+		if a.Value.Kind() == slog.KindString && a.Value.String() == "" {
+			return a
+		}
+		return slog.String(a.Key, redacted)
+	}
+
+	switch a.Value.Kind() {
+	case slog.KindString:
+		return slog.String(a.Key, RedactString(a.Value.String()))
+	case slog.KindAny:
+		if err, ok := a.Value.Any().(error); ok {
+			return slog.String(a.Key, RedactString(err.Error()))
+		}
+	}
+	return a
+}
+
+// RedactString masks JWTs, bearer credentials and email addresses in s
+func RedactString(s string) string {
+	s = jwtPattern.ReplaceAllString(s, redacted)
+	s = bearerPattern.ReplaceAllString(s, "Bearer "+redacted)
+	return emailPattern.ReplaceAllStringFunc(s, MaskEmail)
+}
+
+// MaskEmail keeps the first character of the local part and the domain,
+// e.g. "alice@example.com" becomes "a***@example.com".
+func MaskEmail(email string) string {
+	at := strings.LastIndex(email, "@")
+	if at <= 0 {
+		return redacted
+	}
+	return email[:1] + "***" + email[at:]
+}
+
+func isSensitiveKey(key string) bool {
+	for _, k := range sensitiveKeys {
+		if strings.Contains(key, k) {
+			return true
+		}
+	}
+	return false
+}
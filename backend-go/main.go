@@ -1,8 +1,14 @@
 package main
 
 import (
-	"log"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-contrib/sessions"
@@ -11,6 +17,8 @@ import (
 
 	"keycloak-logout-backend-go/config"
 	"keycloak-logout-backend-go/handlers"
+	"keycloak-logout-backend-go/logging"
+	"keycloak-logout-backend-go/metrics"
 	"keycloak-logout-backend-go/middleware"
 	"keycloak-logout-backend-go/services"
 )
@@ -18,29 +26,73 @@ import (
 func main() {
 	// Load configuration
 	cfg := config.LoadConfig()
-	log.Printf("Starting server with config - Keycloak: %s, Realm: %s", cfg.KeycloakURL, cfg.KeycloakRealm)
+	slog.SetDefault(logging.New(cfg, os.Stdout))
+	slog.Info("starting server", "keycloak", cfg.KeycloakURL, "realm", cfg.KeycloakRealm)
 
 	// Initialize services
-	authService, err := services.NewAuthService(cfg)
+	authService, err := services.NewAuthService(cfg, services.NewMemoryReplayCache())
 	if err != nil {
-		log.Fatal("Failed to initialize auth service: ", err)
+		fatal("failed to initialize auth service", err)
 	}
 
-	sessionService := services.NewSessionService()
+	sessionStore, err := newSessionStore(cfg)
+	if err != nil {
+		fatal("failed to initialize session store", err)
+	}
+	if snapshotEnabled(cfg) {
+		restored, err := services.LoadSnapshot(sessionStore, cfg.SessionSnapshotPath)
+		if err != nil {
+			fatal("failed to restore session snapshot", err)
+		}
+		slog.Info("restored sessions from snapshot", "path", cfg.SessionSnapshotPath, "sessions", restored)
+	}
+
+	eventBus, err := newEventBus(cfg)
+	if err != nil {
+		fatal("failed to initialize event bus", err)
+	}
+
+	if err := services.ValidateDropPolicy(cfg.NotifyDropPolicy); err != nil {
+		fatal("invalid notification settings", err)
+	}
+	sessionService := services.NewSessionService(sessionStore, eventBus, services.NotifyOptions{
+		QueueSize:         cfg.NotifyQueueSize,
+		DropPolicy:        cfg.NotifyDropPolicy,
+		DispatchQueueSize: cfg.NotifyDispatchQueueSize,
+	})
+	metrics.RegisterActiveSessions(sessionService.ActiveSessionCount)
+	metrics.RegisterQueueDepths(sessionService.DispatchQueueDepth, sessionService.QueuedEvents)
+
+	tokenManager := services.NewTokenManager(authService, sessionService)
+
+	// Background jobs run until shutdown
+	backgroundCtx, stopBackground := context.WithCancel(context.Background())
+	defer stopBackground()
+	sessionService.StartSweeper(backgroundCtx, cfg.SessionSweepInterval, cfg.SessionIdleTimeout)
+	tokenManager.StartRefresher(backgroundCtx, cfg.TokenRefreshInterval, cfg.TokenRefreshActiveWindow)
 
 	// Initialize handlers
 	authHandler := handlers.NewAuthHandler(cfg, authService, sessionService)
-	apiHandler := handlers.NewAPIHandler(sessionService)
+	apiHandler := handlers.NewAPIHandler(cfg, sessionService)
+	wsHandler := handlers.NewWebSocketHandler(cfg, sessionService)
+	var idpTerminator services.IdPSessionTerminator
+	if cfg.IdPAdminEnabled {
+		slog.Info("Keycloak admin API enabled for forced logout", "url", cfg.GetAdminAPIURL())
+		idpTerminator = services.NewKeycloakAdminClient(cfg)
+	}
+	forcedLogout := services.NewForcedLogoutService(sessionService, idpTerminator)
+	adminHandler := handlers.NewAdminHandler(sessionService, forcedLogout)
 
 	// Setup Gin
-	r := gin.Default()
+	r := gin.New()
+	r.Use(middleware.RequestLogger(), gin.Recovery())
 
 	// CORS configuration
 	r.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{cfg.FrontendURL},
 		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization"},
-		ExposeHeaders:    []string{"Content-Length"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization", middleware.RequestIDHeader},
+		ExposeHeaders:    []string{"Content-Length", middleware.RequestIDHeader},
 		AllowCredentials: true,
 	}))
 
@@ -57,7 +109,7 @@ func main() {
 	
 	store.Options(sessions.Options{
 		Path:     "/",
-		MaxAge:   24 * 60 * 60,    // 24시간
+		MaxAge:   int(cfg.SessionMaxLifetime.Seconds()), // 세션 최대 수명 (기본 24시간)
 		HttpOnly: false,           // 디버깅을 위해 false (CORS 환경)
 		Secure:   cfg.IsHTTPS(),  // HTTPS 환경에서는 true
 		SameSite: sameSiteMode,   // 환경에 따라 다르게 설정
@@ -66,32 +118,164 @@ func main() {
 	r.Use(sessions.Sessions("keycloak-session", store))
 
 	// Setup routes
-	setupRoutes(r, authHandler, apiHandler)
+	setupRoutes(r, cfg, authHandler, apiHandler, wsHandler, adminHandler, sessionService, authService)
+
+	// Start server. SIGTERM (sent by Kubernetes) or Ctrl-C starts a graceful
+	// shutdown; a second signal kills the process.
+	signalCtx, stopSignals := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stopSignals()
+
+	srv := &http.Server{Addr: ":" + cfg.Port, Handler: r}
+	serverErr := make(chan error, 1)
+	go func() {
+		slog.Info("Go Backend server running", "url", "http://localhost:"+cfg.Port)
+		serverErr <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serverErr:
+		if !errors.Is(err, http.ErrServerClosed) {
+			fatal("server stopped", err)
+		}
+	case <-signalCtx.Done():
+		stopSignals()
+	}
+
+	shutdown(cfg, srv, authHandler, sessionService, sessionStore, eventBus, stopBackground)
+}
+
+// shutdown stops the server within cfg.ShutdownTimeout. Logins are refused,
+// event stream clients are told to reconnect, in-flight requests finish,
+// queued notifications are published and the session store is persisted.
+func shutdown(cfg *config.Config, srv *http.Server, authHandler *handlers.AuthHandler, sessionService *services.SessionService,
+	sessionStore services.SessionStore, eventBus services.EventBus, stopBackground context.CancelFunc) {
+	slog.Info("shutting down", "timeout", cfg.ShutdownTimeout)
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+
+	authHandler.StopLogins()
+	notified := sessionService.NotifyShutdown(cfg.ShutdownRetryHint)
+	slog.Info("event stream clients told to reconnect", "subscribers", notified, "retry", cfg.ShutdownRetryHint)
+
+	// Shutdown closes the listener and waits for requests, including the
+	// event streams ending on server_shutdown, to finish
+	if err := srv.Shutdown(ctx); err != nil {
+		slog.Warn("requests still running at shutdown deadline, closing connections", "error", err)
+		srv.Close()
+	}
 
-	// Start server
-	log.Printf("Go Backend server running on http://localhost:%s", cfg.Port)
-	log.Fatal(r.Run(":" + cfg.Port))
+	stopBackground()
+	if err := sessionService.Close(ctx); err != nil {
+		slog.Warn("not all queued session events were published", "error", err)
+	}
+	if err := eventBus.Close(); err != nil {
+		slog.Warn("failed to close event bus", "error", err)
+	}
+
+	if snapshotEnabled(cfg) {
+		saved, err := services.SaveSnapshot(sessionStore, cfg.SessionSnapshotPath)
+		if err != nil {
+			slog.Error("failed to save session snapshot", "error", err)
+		} else {
+			slog.Info("saved session snapshot", "path", cfg.SessionSnapshotPath, "sessions", saved)
+		}
+	}
+	if err := sessionStore.Close(); err != nil {
+		slog.Error("failed to persist session store", "error", err)
+	}
+	slog.Info("server stopped")
+}
+
+// snapshotEnabled reports whether the memory session store is saved across
+// restarts. The file store persists every change by itself.
+func snapshotEnabled(cfg *config.Config) bool {
+	return cfg.SessionStore == "memory" && cfg.SessionSnapshotPath != ""
+}
+
+// fatal logs a startup or server error and exits
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
+}
+
+// newSessionStore creates the session store backend selected in the config
+func newSessionStore(cfg *config.Config) (services.SessionStore, error) {
+	switch cfg.SessionStore {
+	case "memory":
+		return services.NewMemorySessionStore(), nil
+	case "file":
+		slog.Info("using file session store", "path", cfg.SessionStorePath)
+		return services.NewFileSessionStore(cfg.SessionStorePath)
+	default:
+		return nil, fmt.Errorf("unknown session store %q", cfg.SessionStore)
+	}
+}
+
+// newEventBus creates the event bus selected in the config, starting an
+// embedded broker when requested
+func newEventBus(cfg *config.Config) (services.EventBus, error) {
+	switch cfg.EventBus {
+	case "local":
+		return services.NewLocalEventBus(), nil
+	case "tcp":
+		if cfg.EventBusBrokerListen != "" {
+			broker, err := services.NewTCPBroker(cfg.EventBusBrokerListen, cfg.EventBusSecret)
+			if err != nil {
+				return nil, err
+			}
+			slog.Info("event broker listening", "addr", broker.Addr())
+		}
+		slog.Info("using TCP event bus", "broker", cfg.EventBusAddr)
+		return services.NewTCPEventBus(cfg.EventBusAddr, cfg.EventBusSecret)
+	default:
+		return nil, fmt.Errorf("unknown event bus %q", cfg.EventBus)
+	}
 }
 
-func setupRoutes(r *gin.Engine, authHandler *handlers.AuthHandler, apiHandler *handlers.APIHandler) {
+func setupRoutes(r *gin.Engine, cfg *config.Config, authHandler *handlers.AuthHandler, apiHandler *handlers.APIHandler, wsHandler *handlers.WebSocketHandler, adminHandler *handlers.AdminHandler, sessionService *services.SessionService, authService *services.AuthService) {
 	// Authentication routes
 	r.GET("/auth/login", authHandler.HandleLogin)
 	r.GET("/auth/callback", authHandler.HandleCallback)
 	r.GET("/auth/logout", authHandler.HandleLogout)
+	r.GET("/auth/logout/callback", authHandler.HandleLogoutCallback)
 	r.POST("/auth/backchannel-logout", authHandler.HandleBackchannelLogout)
+	r.GET("/auth/frontchannel-logout", authHandler.HandleFrontchannelLogout)
 
-	// API routes (with authentication)
+	// API routes (cookie session or bearer token)
 	api := r.Group("/api")
-	api.Use(middleware.RequireAuth())
+	api.Use(middleware.RequireCookieOrBearer(sessionService, authService))
 	{
 		api.GET("/user", apiHandler.HandleGetUser)
-		api.GET("/events", apiHandler.HandleSSE)
+	}
+
+	// Browser API routes (cookie session only). An ended session may still
+	// collect the SSE or long-poll events it missed before authentication
+	// rejects it.
+	requireAuth := middleware.RequireAuth(sessionService)
+	browser := r.Group("/api")
+	{
+		browser.GET("/events", apiHandler.HandleSSEReplay, requireAuth, apiHandler.HandleSSE)
+		browser.GET("/events/poll", apiHandler.HandlePollReplay, requireAuth, apiHandler.HandlePoll)
+		browser.GET("/ws", requireAuth, wsHandler.HandleWebSocket)
+	}
+
+	// Admin API routes (admin realm role required)
+	admin := r.Group("/api")
+	admin.Use(middleware.RequireCookieOrBearer(sessionService, authService), middleware.RequireRealmRole(cfg.AdminRole))
+	{
+		admin.GET("/sessions", apiHandler.HandleGetSessions)
+		admin.GET("/admin/sessions", adminHandler.HandleListSessions)
+		admin.DELETE("/admin/sessions/:id", adminHandler.HandleDeleteSession)
+		admin.DELETE("/admin/users/:id/sessions", adminHandler.HandleDeleteUserSessions)
+		admin.GET("/admin/subscribers", adminHandler.HandleListSubscribers)
 	}
 
 	// Public API routes
-	r.GET("/api/sessions", apiHandler.HandleGetSessions)
 	r.GET("/api/session-status", apiHandler.HandleSessionStatus)
 
+	// Prometheus metrics
+	r.GET("/metrics", gin.WrapH(metrics.Handler()))
+
 	// Test endpoint
 	r.GET("/auth/backchannel-logout", authHandler.HandleBackchannelLogoutTest)
 }
\ No newline at end of file
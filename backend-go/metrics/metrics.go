@@ -0,0 +1,119 @@
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "logout_sample"
+
+// Outcomes recorded by BackchannelLogouts besides the logout token error codes
+const (
+	OutcomeLoggedOut    = "logged_out"
+	OutcomeNoSession    = "no_session"
+	OutcomeMissingToken = "missing_token"
+	OutcomeInvalidToken = "invalid_token"
+)
+
+// Results recorded by Notifications
+const (
+	NotificationDelivered = "delivered"
+	NotificationDropped   = "dropped"
+)
+
+var (
+	// Logins counts completed logins
+	Logins = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "logins_total",
+		Help:      "Completed OIDC logins.",
+	})
+
+	// CallbackFailures counts failed login callbacks by reason
+	CallbackFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "callback_failures_total",
+		Help:      "Failed OIDC login callbacks by reason.",
+	}, []string{"reason"})
+
+	// BackchannelLogouts counts back-channel logout requests by outcome. A
+	// rejected logout token is recorded under its error code.
+	BackchannelLogouts = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "backchannel_logouts_total",
+		Help:      "Back-channel logout requests by outcome.",
+	}, []string{"outcome"})
+
+	// Notifications counts session events queued to local subscribers and
+	// events discarded from full subscriber queues
+	Notifications = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "notifications_total",
+		Help:      "Session event notifications to subscribers by result (delivered or dropped).",
+	}, []string{"result"})
+
+	// DispatchDropped counts session events lost because the dispatch queue
+	// was full
+	DispatchDropped = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "dispatch_dropped_total",
+		Help:      "Session events dropped because the dispatch queue was full.",
+	})
+
+	// Subscribers is the number of open event connections on this replica
+	// by transport (sse, ws or poll)
+	Subscribers = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "subscribers",
+		Help:      "Open session event connections by transport.",
+	}, []string{"transport"})
+
+	// TokenExchangeDuration observes authorization code exchange latency
+	TokenExchangeDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "token_exchange_duration_seconds",
+		Help:      "Latency of authorization code exchanges with the IdP.",
+		Buckets:   prometheus.DefBuckets,
+	})
+)
+
+// RegisterActiveSessions exposes the number of active sessions, read from
+// count at scrape time so it always matches the session store
+func RegisterActiveSessions(count func() int) {
+	prometheus.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "active_sessions",
+		Help:      "Active user sessions in the session store.",
+	}, func() float64 {
+		return float64(count())
+	}))
+}
+
+// RegisterQueueDepths exposes the number of events waiting in the dispatch
+// queue and in subscriber queues, read at scrape time
+func RegisterQueueDepths(dispatch func() int, subscribers func() int) {
+	prometheus.MustRegister(
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "dispatch_queue_depth",
+			Help:      "Session events waiting to be published on the event bus.",
+		}, func() float64 {
+			return float64(dispatch())
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "subscriber_queue_depth",
+			Help:      "Session events queued for subscribers, summed over all subscribers.",
+		}, func() float64 {
+			return float64(subscribers())
+		}),
+	)
+}
+
+// Handler serves the metrics in the Prometheus exposition format
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
@@ -1,33 +1,131 @@
 package middleware
 
 import (
-	"log"
+	"log/slog"
 	"net/http"
+	"strings"
 
 	"github.com/gin-contrib/sessions"
 	"github.com/gin-gonic/gin"
+
+	"keycloak-logout-backend-go/logging"
+	"keycloak-logout-backend-go/models"
+	"keycloak-logout-backend-go/services"
+)
+
+// Context keys set by the authentication middleware, in addition to
+// "user_id" and (for cookie sessions) "session_id"
+const (
+	UserKey   = "user"   // models.UserProfile
+	ClaimsKey = "claims" // map[string]interface{}, bearer only
 )
 
-// RequireAuth middleware ensures user is authenticated
-func RequireAuth() gin.HandlerFunc {
+// RequireAuth middleware ensures the cookie references an active session
+func RequireAuth(sessionSvc *services.SessionService) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		session := sessions.Default(c)
-		userID := session.Get("user_id")
-
-		log.Printf("requireAuth: session userID = %v", userID)
-		log.Printf("requireAuth: session ID = %s", session.ID())
-		
-		// userID가 존재하는지, 그리고 string 타입이 맞는지 확인합니다.
-		userIDStr, ok := userID.(string)
-		if !ok || userIDStr == "" {
-			log.Printf("requireAuth: No valid user_id in session. userID: %v", userID)
+		sessionID := session.Get("session_id")
+		ctx := c.Request.Context()
+
+		// session_id가 존재하는지, 그리고 string 타입이 맞는지 확인합니다.
+		sessionIDStr, ok := sessionID.(string)
+		if !ok || sessionIDStr == "" {
+			slog.DebugContext(ctx, "requireAuth: no session_id in cookie session")
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
 			c.Abort()
 			return
 		}
 
-		log.Printf("requireAuth: Authenticated user: %s", userIDStr)
-		c.Set("user_id", userIDStr)
+		sessionData, exists := sessionSvc.GetSession(ctx, sessionIDStr)
+		if !exists {
+			slog.InfoContext(ctx, "requireAuth: session no longer active", "session_id", sessionIDStr)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Session not found"})
+			c.Abort()
+			return
+		}
+
+		logging.SetUserID(ctx, sessionData.User.ID)
+		slog.DebugContext(ctx, "requireAuth: authenticated", "session_id", sessionIDStr)
+		sessionSvc.TouchSession(ctx, sessionIDStr)
+		c.Set("session_id", sessionIDStr)
+		c.Set("user_id", sessionData.User.ID)
+		c.Set(UserKey, sessionData.User)
 		c.Next()
 	}
-}
\ No newline at end of file
+}
+
+// RequireBearer middleware authenticates requests with an
+// "Authorization: Bearer" access token issued by the realm
+func RequireBearer(authSvc *services.AuthService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		rawToken, ok := bearerToken(c)
+		if !ok {
+			c.Header("WWW-Authenticate", `Bearer`)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Missing bearer token"})
+			c.Abort()
+			return
+		}
+
+		claims, err := authSvc.VerifyAccessToken(c.Request.Context(), rawToken)
+		if err != nil {
+			slog.InfoContext(c.Request.Context(), "requireBearer: token rejected", "error", err)
+			c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid bearer token"})
+			c.Abort()
+			return
+		}
+
+		profile := authSvc.ExtractUserProfile(claims)
+		logging.SetUserID(c.Request.Context(), profile.ID)
+		slog.DebugContext(c.Request.Context(), "requireBearer: authenticated")
+		c.Set("user_id", profile.ID)
+		c.Set(UserKey, profile)
+		c.Set(ClaimsKey, claims)
+		c.Next()
+	}
+}
+
+// RequireCookieOrBearer middleware uses bearer authentication when the
+// request carries an Authorization header and the cookie session otherwise
+func RequireCookieOrBearer(sessionSvc *services.SessionService, authSvc *services.AuthService) gin.HandlerFunc {
+	cookieAuth := RequireAuth(sessionSvc)
+	bearerAuth := RequireBearer(authSvc)
+	return func(c *gin.Context) {
+		if c.GetHeader("Authorization") != "" {
+			bearerAuth(c)
+			return
+		}
+		cookieAuth(c)
+	}
+}
+
+// GetClaims returns the access token claims of a bearer-authenticated request
+func GetClaims(c *gin.Context) (map[string]interface{}, bool) {
+	claims, ok := c.Get(ClaimsKey)
+	if !ok {
+		return nil, false
+	}
+	m, ok := claims.(map[string]interface{})
+	return m, ok
+}
+
+// GetUser returns the profile of the authenticated user
+func GetUser(c *gin.Context) (models.UserProfile, bool) {
+	user, ok := c.Get(UserKey)
+	if !ok {
+		return models.UserProfile{}, false
+	}
+	profile, ok := user.(models.UserProfile)
+	return profile, ok
+}
+
+// bearerToken extracts the token from an "Authorization: Bearer" header
+func bearerToken(c *gin.Context) (string, bool) {
+	header := c.GetHeader("Authorization")
+	scheme, token, found := strings.Cut(header, " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
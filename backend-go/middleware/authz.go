@@ -0,0 +1,54 @@
+package middleware
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"keycloak-logout-backend-go/models"
+)
+
+// RequireRealmRole middleware allows users holding any of the given realm
+// roles (realm_access.roles). It must run after an authentication middleware.
+func RequireRealmRole(roles ...string) gin.HandlerFunc {
+	return requireProfile("realm role", roles, func(profile models.UserProfile) bool {
+		return profile.HasRealmRole(roles...)
+	})
+}
+
+// RequireClientRole middleware allows users holding any of the given roles
+// of a client (resource_access.<client>.roles)
+func RequireClientRole(clientID string, roles ...string) gin.HandlerFunc {
+	return requireProfile("client role", roles, func(profile models.UserProfile) bool {
+		return profile.HasClientRole(clientID, roles...)
+	})
+}
+
+// RequireGroup middleware allows members of any of the given groups
+func RequireGroup(groups ...string) gin.HandlerFunc {
+	return requireProfile("group", groups, func(profile models.UserProfile) bool {
+		return profile.InGroup(groups...)
+	})
+}
+
+// requireProfile aborts with 403 unless the authenticated user satisfies allow
+func requireProfile(kind string, required []string, allow func(models.UserProfile) bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		profile, ok := GetUser(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+			c.Abort()
+			return
+		}
+
+		if !allow(profile) {
+			slog.InfoContext(c.Request.Context(), "authz: access denied", "kind", kind, "required", required)
+			c.JSON(http.StatusForbidden, gin.H{"error": "Forbidden"})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
@@ -0,0 +1,62 @@
+package middleware
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"keycloak-logout-backend-go/logging"
+)
+
+// RequestIDHeader carries the request ID in both directions
+const RequestIDHeader = "X-Request-ID"
+
+// RequestLogger middleware assigns each request an ID (reusing a sane
+// incoming X-Request-ID), attaches it to the request context so every log
+// line carries it, and writes one access log line per request.
+func RequestLogger() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestID := c.GetHeader(RequestIDHeader)
+		if !validRequestID(requestID) {
+			requestID = uuid.New().String()
+		}
+		c.Header(RequestIDHeader, requestID)
+
+		ctx := logging.WithRequest(c.Request.Context(), requestID)
+		c.Request = c.Request.WithContext(ctx)
+
+		start := time.Now()
+		c.Next()
+
+		status := c.Writer.Status()
+		level := slog.LevelInfo
+		if status >= 500 {
+			level = slog.LevelError
+		}
+		slog.Log(ctx, level, "request",
+			"method", c.Request.Method,
+			"path", c.Request.URL.Path,
+			"status", status,
+			"duration", time.Since(start),
+			"client_ip", c.ClientIP(),
+		)
+	}
+}
+
+// validRequestID accepts short IDs made of URL-safe characters so a client
+// cannot inject arbitrary content into log lines
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+	for _, r := range id {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+// SessionEvent is a notification about a session, sent to its subscribers.
+// IDs increase over time, so a client can resume after the last one it saw.
+// RetryMs, set on server_shutdown, hints how long to wait before reconnecting.
+type SessionEvent struct {
+	ID        string    `json:"id,omitempty"`
+	Type      string    `json:"type"`
+	SessionID string    `json:"sessionId"`
+	Reason    string    `json:"reason,omitempty"`
+	RetryMs   int64     `json:"retryMs,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
@@ -0,0 +1,50 @@
+package models
+
+import "encoding/json"
+
+// BackchannelLogoutEvent is the events member that identifies a logout token
+const BackchannelLogoutEvent = "http://schemas.openid.net/event/backchannel-logout"
+
+// LogoutToken represents the claims of an OIDC Back-Channel Logout token.
+// Nonce holds the raw nonce claim so that any nonce member, even null, is
+// seen; logout tokens must not carry one.
+type LogoutToken struct {
+	Issuer    string                     `json:"iss"`
+	Subject   string                     `json:"sub,omitempty"`
+	Audience  Audience                   `json:"aud"`
+	IssuedAt  int64                      `json:"iat"`
+	ExpiresAt int64                      `json:"exp,omitempty"`
+	JTI       string                     `json:"jti,omitempty"`
+	SessionID string                     `json:"sid,omitempty"`
+	Events    map[string]json.RawMessage `json:"events"`
+	Nonce     json.RawMessage            `json:"nonce,omitempty"`
+}
+
+// Audience is the aud claim, which may be a single string or an array
+type Audience []string
+
+// UnmarshalJSON accepts both the string and the array form of aud
+func (a *Audience) UnmarshalJSON(b []byte) error {
+	var single string
+	if err := json.Unmarshal(b, &single); err == nil {
+		*a = Audience{single}
+		return nil
+	}
+
+	var multi []string
+	if err := json.Unmarshal(b, &multi); err != nil {
+		return err
+	}
+	*a = Audience(multi)
+	return nil
+}
+
+// Contains reports whether the audience includes the given client ID
+func (a Audience) Contains(clientID string) bool {
+	for _, aud := range a {
+		if aud == clientID {
+			return true
+		}
+	}
+	return false
+}
@@ -1,6 +1,9 @@
 package models
 
-import "time"
+import (
+	"strings"
+	"time"
+)
 
 // UserProfile represents user information from OIDC token
 type UserProfile struct {
@@ -14,20 +17,74 @@ type UserProfile struct {
 	Emails []struct {
 		Value string `json:"value"`
 	} `json:"emails"`
+
+	// Keycloak authorization claims (realm_access, resource_access, groups)
+	RealmRoles  []string            `json:"realmRoles,omitempty"`
+	ClientRoles map[string][]string `json:"clientRoles,omitempty"`
+	Groups      []string            `json:"groups,omitempty"`
+}
+
+// HasRealmRole reports whether the user has any of the given realm roles
+func (p UserProfile) HasRealmRole(roles ...string) bool {
+	return containsAny(p.RealmRoles, roles)
+}
+
+// HasClientRole reports whether the user has any of the given roles of a client
+func (p UserProfile) HasClientRole(clientID string, roles ...string) bool {
+	return containsAny(p.ClientRoles[clientID], roles)
+}
+
+// InGroup reports whether the user belongs to any of the given groups. Group
+// paths match with or without the leading slash Keycloak adds.
+func (p UserProfile) InGroup(groups ...string) bool {
+	for _, have := range p.Groups {
+		for _, want := range groups {
+			if strings.TrimPrefix(have, "/") == strings.TrimPrefix(want, "/") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// containsAny reports whether have and want share an element
+func containsAny(have, want []string) bool {
+	for _, h := range have {
+		for _, w := range want {
+			if h == w {
+				return true
+			}
+		}
+	}
+	return false
 }
 
 // SessionData represents an active user session
 type SessionData struct {
-	SessionID string      `json:"sessionId"`
-	User      UserProfile `json:"user"`
-	LoginTime time.Time   `json:"loginTime"`
+	SessionID    string      `json:"sessionId"`
+	IdPSessionID string      `json:"sid,omitempty"` // Keycloak session ID (sid claim)
+	User         UserProfile `json:"user"`
+	LoginTime    time.Time   `json:"loginTime"`
+	LastSeen     time.Time   `json:"lastSeen"`  // last authenticated request
+	ExpiresAt    time.Time   `json:"expiresAt"` // absolute expiry
+	Tokens       *TokenSet   `json:"tokens,omitempty"`
+}
+
+// TokenSet holds the OAuth2 tokens issued for a session. It is kept
+// server-side only and never sent to the browser.
+type TokenSet struct {
+	AccessToken  string    `json:"accessToken"`
+	RefreshToken string    `json:"refreshToken,omitempty"`
+	TokenType    string    `json:"tokenType,omitempty"`
+	Expiry       time.Time `json:"expiry,omitempty"`
+	IDToken      string    `json:"idToken,omitempty"`
 }
 
-// SSEClient represents a Server-Sent Events client connection
-type SSEClient struct {
-	UserID string
-	C      chan string
-	Done   chan bool
+// RevocationResult reports the outcome of revoking one token at the IdP
+type RevocationResult struct {
+	TokenTypeHint string `json:"tokenTypeHint"`
+	Revoked       bool   `json:"revoked"`
+	Error         string `json:"error,omitempty"`
 }
 
 // SessionStatus represents the current authentication status
@@ -3,8 +3,15 @@ package services
 import (
 	"context"
 	"crypto/rand"
+	"crypto/subtle"
 	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
 
 	"github.com/coreos/go-oidc/v3/oidc"
 	"github.com/golang-jwt/jwt/v5"
@@ -14,17 +21,32 @@ import (
 	"keycloak-logout-backend-go/models"
 )
 
+// ErrNonceMismatch is returned when an ID token's nonce does not match the
+// login request
+var ErrNonceMismatch = errors.New("ID token nonce mismatch")
+
+// ErrRevocationUnsupported is returned when the provider advertises no
+// revocation_endpoint
+var ErrRevocationUnsupported = errors.New("provider has no revocation endpoint")
+
 // AuthService handles OIDC authentication
 type AuthService struct {
-	config       *config.Config
-	oauth2Config *oauth2.Config
-	oidcVerifier *oidc.IDTokenVerifier
+	config         *config.Config
+	oauth2Config   *oauth2.Config
+	oidcVerifier   *oidc.IDTokenVerifier
+	bearerVerifier *oidc.IDTokenVerifier
+	keySet         oidc.KeySet
+	validator      *LogoutTokenValidator
+	replayCache    ReplayCache
+
+	endSessionEndpoint string
+	revocationEndpoint string
 }
 
 // NewAuthService creates a new authentication service
-func NewAuthService(cfg *config.Config) (*AuthService, error) {
+func NewAuthService(cfg *config.Config, replayCache ReplayCache) (*AuthService, error) {
 	ctx := context.Background()
-	
+
 	provider, err := oidc.NewProvider(ctx, cfg.GetIssuerURL())
 	if err != nil {
 		return nil, fmt.Errorf("failed to get OIDC provider: %w", err)
@@ -40,10 +62,38 @@ func NewAuthService(cfg *config.Config) (*AuthService, error) {
 
 	oidcVerifier := provider.Verifier(&oidc.Config{ClientID: cfg.ClientID})
 
+	// Bearer access tokens are checked for signature, issuer, expiry and an
+	// audience that includes BearerAudience
+	bearerVerifier := provider.Verifier(&oidc.Config{ClientID: cfg.BearerAudience})
+
+	// Logout tokens are signed with the same realm keys as ID tokens. The
+	// remote key set caches them and refetches when an unknown kid appears.
+	var providerClaims struct {
+		JWKSURL            string `json:"jwks_uri"`
+		EndSessionEndpoint string `json:"end_session_endpoint"`
+		RevocationEndpoint string `json:"revocation_endpoint"`
+	}
+	if err := provider.Claims(&providerClaims); err != nil {
+		return nil, fmt.Errorf("failed to read provider metadata: %w", err)
+	}
+	if providerClaims.JWKSURL == "" {
+		return nil, fmt.Errorf("provider metadata has no jwks_uri")
+	}
+	if providerClaims.EndSessionEndpoint == "" {
+		providerClaims.EndSessionEndpoint = cfg.GetEndSessionEndpoint()
+	}
+
 	return &AuthService{
-		config:       cfg,
-		oauth2Config: oauth2Config,
-		oidcVerifier: oidcVerifier,
+		config:         cfg,
+		oauth2Config:   oauth2Config,
+		oidcVerifier:   oidcVerifier,
+		bearerVerifier: bearerVerifier,
+		keySet:         oidc.NewRemoteKeySet(ctx, providerClaims.JWKSURL),
+		validator:      NewLogoutTokenValidator(cfg),
+		replayCache:    replayCache,
+
+		endSessionEndpoint: providerClaims.EndSessionEndpoint,
+		revocationEndpoint: providerClaims.RevocationEndpoint,
 	}, nil
 }
 
@@ -54,23 +104,130 @@ func (a *AuthService) GenerateState() string {
 	return base64.URLEncoding.EncodeToString(b)
 }
 
-// GetAuthURL returns the OAuth2 authorization URL
-func (a *AuthService) GetAuthURL(state string) string {
-	return a.oauth2Config.AuthCodeURL(state)
+// GenerateNonce generates a random nonce for the ID token
+func (a *AuthService) GenerateNonce() string {
+	return a.GenerateState()
+}
+
+// GeneratePKCEVerifier generates a PKCE code verifier
+func (a *AuthService) GeneratePKCEVerifier() string {
+	return oauth2.GenerateVerifier()
+}
+
+// GetAuthURL returns the OAuth2 authorization URL with the nonce and the
+// S256 PKCE challenge derived from verifier
+func (a *AuthService) GetAuthURL(state, nonce, verifier string) string {
+	return a.oauth2Config.AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(verifier))
+}
+
+// ExchangeCode exchanges authorization code for tokens, sending the PKCE
+// code verifier
+func (a *AuthService) ExchangeCode(ctx context.Context, code, verifier string) (*oauth2.Token, error) {
+	return a.oauth2Config.Exchange(ctx, code, oauth2.VerifierOption(verifier))
+}
+
+// EndSessionURL builds the RP-initiated logout URL for the discovered
+// end_session_endpoint. idTokenHint may be empty if the session has no ID
+// token, in which case Keycloak asks the user to confirm.
+func (a *AuthService) EndSessionURL(idTokenHint, postLogoutRedirectURI, state string) string {
+	params := url.Values{}
+	if idTokenHint != "" {
+		params.Set("id_token_hint", idTokenHint)
+	}
+	params.Set("post_logout_redirect_uri", postLogoutRedirectURI)
+	params.Set("client_id", a.config.ClientID)
+	params.Set("state", state)
+
+	separator := "?"
+	if strings.Contains(a.endSessionEndpoint, "?") {
+		separator = "&"
+	}
+	return a.endSessionEndpoint + separator + params.Encode()
+}
+
+// RevokeToken revokes a token at the provider's revocation endpoint
+// (RFC 7009), authenticating with the client credentials
+func (a *AuthService) RevokeToken(ctx context.Context, token, tokenTypeHint string) error {
+	if a.revocationEndpoint == "" {
+		return ErrRevocationUnsupported
+	}
+
+	form := url.Values{}
+	form.Set("token", token)
+	if tokenTypeHint != "" {
+		form.Set("token_type_hint", tokenTypeHint)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.revocationEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return fmt.Errorf("failed to build revocation request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(a.config.ClientID), url.QueryEscape(a.config.ClientSecret))
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("revocation request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+
+	var errResp struct {
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if json.Unmarshal(body, &errResp) == nil && errResp.Error != "" {
+		return fmt.Errorf("revocation failed (%d): %s: %s", resp.StatusCode, errResp.Error, errResp.ErrorDescription)
+	}
+	return fmt.Errorf("revocation failed with status %d", resp.StatusCode)
 }
 
-// ExchangeCode exchanges authorization code for tokens
-func (a *AuthService) ExchangeCode(ctx context.Context, code string) (*oauth2.Token, error) {
-	return a.oauth2Config.Exchange(ctx, code)
+// RevokeTokens revokes the refresh and access tokens of a session and
+// reports the outcome for each
+func (a *AuthService) RevokeTokens(ctx context.Context, tokens *models.TokenSet) []models.RevocationResult {
+	var results []models.RevocationResult
+	if tokens == nil {
+		return results
+	}
+
+	for _, t := range []struct{ value, hint string }{
+		{tokens.RefreshToken, "refresh_token"},
+		{tokens.AccessToken, "access_token"},
+	} {
+		if t.value == "" {
+			continue
+		}
+		result := models.RevocationResult{TokenTypeHint: t.hint, Revoked: true}
+		if err := a.RevokeToken(ctx, t.value, t.hint); err != nil {
+			result.Revoked = false
+			result.Error = err.Error()
+		}
+		results = append(results, result)
+	}
+	return results
 }
 
-// VerifyIDToken verifies and returns ID token claims
-func (a *AuthService) VerifyIDToken(ctx context.Context, rawIDToken string) (map[string]interface{}, error) {
+// RefreshToken obtains new tokens with a refresh token
+func (a *AuthService) RefreshToken(ctx context.Context, refreshToken string) (*oauth2.Token, error) {
+	return a.oauth2Config.TokenSource(ctx, &oauth2.Token{RefreshToken: refreshToken}).Token()
+}
+
+// VerifyIDToken verifies and returns ID token claims. The token's nonce must
+// match the one sent in the authorization request.
+func (a *AuthService) VerifyIDToken(ctx context.Context, rawIDToken, nonce string) (map[string]interface{}, error) {
 	idToken, err := a.oidcVerifier.Verify(ctx, rawIDToken)
 	if err != nil {
 		return nil, fmt.Errorf("ID token verification failed: %w", err)
 	}
 
+	if nonce == "" || subtle.ConstantTimeCompare([]byte(idToken.Nonce), []byte(nonce)) != 1 {
+		return nil, ErrNonceMismatch
+	}
+
 	var claims map[string]interface{}
 	if err := idToken.Claims(&claims); err != nil {
 		return nil, fmt.Errorf("claims extraction failed: %w", err)
@@ -79,6 +236,50 @@ func (a *AuthService) VerifyIDToken(ctx context.Context, rawIDToken string) (map
 	return claims, nil
 }
 
+// VerifyRefreshedIDToken verifies an ID token returned by a refresh grant
+// and returns its claims. Such tokens are not bound to a login nonce.
+func (a *AuthService) VerifyRefreshedIDToken(ctx context.Context, rawIDToken string) (map[string]interface{}, error) {
+	idToken, err := a.oidcVerifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, fmt.Errorf("ID token verification failed: %w", err)
+	}
+
+	var claims map[string]interface{}
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, fmt.Errorf("claims extraction failed: %w", err)
+	}
+
+	return claims, nil
+}
+
+// ErrNotAccessToken is returned for a bearer token whose typ claim is not
+// Bearer, such as an ID token issued to the same client
+var ErrNotAccessToken = errors.New("token is not an access token")
+
+// VerifyAccessToken verifies a bearer access token against the realm JWKS
+// and returns its claims. Keycloak marks access tokens with typ "Bearer";
+// ID and refresh tokens, which share the signing keys and may carry the same
+// audience, are rejected.
+func (a *AuthService) VerifyAccessToken(ctx context.Context, rawAccessToken string) (map[string]interface{}, error) {
+	token, err := a.bearerVerifier.Verify(ctx, rawAccessToken)
+	if err != nil {
+		return nil, fmt.Errorf("access token verification failed: %w", err)
+	}
+
+	var claims map[string]interface{}
+	if err := token.Claims(&claims); err != nil {
+		return nil, fmt.Errorf("claims extraction failed: %w", err)
+	}
+	if typ, _ := claims["typ"].(string); typ != "Bearer" {
+		return nil, fmt.Errorf("%w: typ %q", ErrNotAccessToken, typ)
+	}
+	if sub, _ := claims["sub"].(string); sub == "" {
+		return nil, fmt.Errorf("access token has no sub claim")
+	}
+
+	return claims, nil
+}
+
 // ExtractUserProfile extracts user profile from claims
 func (a *AuthService) ExtractUserProfile(claims map[string]interface{}) models.UserProfile {
 	profile := models.UserProfile{
@@ -107,20 +308,75 @@ func (a *AuthService) ExtractUserProfile(claims map[string]interface{}) models.U
 		}{{Value: email}}
 	}
 
+	// Keycloak authorization claims
+	if realmAccess, ok := claims["realm_access"].(map[string]interface{}); ok {
+		profile.RealmRoles = stringSlice(realmAccess["roles"])
+	}
+
+	if resourceAccess, ok := claims["resource_access"].(map[string]interface{}); ok {
+		for client, access := range resourceAccess {
+			access, ok := access.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if roles := stringSlice(access["roles"]); len(roles) > 0 {
+				if profile.ClientRoles == nil {
+					profile.ClientRoles = make(map[string][]string)
+				}
+				profile.ClientRoles[client] = roles
+			}
+		}
+	}
+
+	profile.Groups = stringSlice(claims["groups"])
+
 	return profile
 }
 
-// ParseLogoutToken parses and validates logout token
-func (a *AuthService) ParseLogoutToken(logoutToken string) (map[string]interface{}, error) {
-	token, _, err := new(jwt.Parser).ParseUnverified(logoutToken, jwt.MapClaims{})
+// stringSlice converts a JSON array claim into a string slice, skipping
+// non-string entries
+func stringSlice(value interface{}) []string {
+	items, ok := value.([]interface{})
+	if !ok {
+		return nil
+	}
+	result := make([]string, 0, len(items))
+	for _, item := range items {
+		if s, ok := item.(string); ok {
+			result = append(result, s)
+		}
+	}
+	return result
+}
+
+// ParseLogoutToken verifies the logout token signature against the realm JWKS,
+// validates its claims and rejects tokens whose jti was already accepted
+func (a *AuthService) ParseLogoutToken(ctx context.Context, logoutToken string) (*models.LogoutToken, error) {
+	if _, _, err := new(jwt.Parser).ParseUnverified(logoutToken, jwt.MapClaims{}); err != nil {
+		return nil, ErrLogoutTokenMalformed.wrap(err)
+	}
+
+	payload, err := a.keySet.VerifySignature(ctx, logoutToken)
 	if err != nil {
-		return nil, fmt.Errorf("invalid token format: %w", err)
+		return nil, ErrLogoutTokenSignature.wrap(err)
 	}
 
-	claims, ok := token.Claims.(jwt.MapClaims)
-	if !ok {
-		return nil, fmt.Errorf("invalid claims format")
+	var token models.LogoutToken
+	if err := json.Unmarshal(payload, &token); err != nil {
+		return nil, ErrLogoutTokenMalformed.wrap(err)
+	}
+
+	if err := a.validator.Validate(&token); err != nil {
+		return nil, err
 	}
 
-	return map[string]interface{}(claims), nil
-}
\ No newline at end of file
+	fresh, err := a.replayCache.MarkSeen(token.JTI, a.validator.AcceptedUntil(&token))
+	if err != nil {
+		return nil, fmt.Errorf("replay cache: %w", err)
+	}
+	if !fresh {
+		return nil, ErrLogoutTokenReplayed.wrap(fmt.Errorf("jti %q", token.JTI))
+	}
+
+	return &token, nil
+}
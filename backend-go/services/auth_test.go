@@ -0,0 +1,241 @@
+package services_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"keycloak-logout-backend-go/models"
+	"keycloak-logout-backend-go/services"
+	"keycloak-logout-backend-go/services/oidctest"
+)
+
+const testClientID = oidctest.ClientID
+
+// newTestAuthService creates an AuthService against a stand-in realm
+func newTestAuthService(t *testing.T) (*services.AuthService, *oidctest.Provider) {
+	t.Helper()
+	idp := oidctest.NewProvider(t)
+	authService, err := services.NewAuthService(idp.Config(testClientID), services.NewMemoryReplayCache())
+	if err != nil {
+		t.Fatalf("NewAuthService: %v", err)
+	}
+	return authService, idp
+}
+
+func TestParseLogoutTokenValidSignature(t *testing.T) {
+	authService, idp := newTestAuthService(t)
+
+	raw := idp.Sign(t, idp.LogoutClaims(testClientID, "user-1", "sid-1"))
+	token, err := authService.ParseLogoutToken(context.Background(), raw)
+	if err != nil {
+		t.Fatalf("ParseLogoutToken: %v", err)
+	}
+	if token.Subject != "user-1" || token.SessionID != "sid-1" {
+		t.Errorf("got sub %q sid %q, want user-1 sid-1", token.Subject, token.SessionID)
+	}
+}
+
+func TestParseLogoutTokenBadSignature(t *testing.T) {
+	authService, idp := newTestAuthService(t)
+
+	raw := idp.SignWithUnpublishedKey(t, idp.LogoutClaims(testClientID, "user-1", "sid-1"))
+	_, err := authService.ParseLogoutToken(context.Background(), raw)
+	if !errors.Is(err, services.ErrLogoutTokenSignature) {
+		t.Fatalf("got %v, want ErrLogoutTokenSignature", err)
+	}
+}
+
+func TestParseLogoutTokenWithNonce(t *testing.T) {
+	authService, idp := newTestAuthService(t)
+
+	for _, nonce := range []interface{}{"n-0S6_WzA2Mj", nil} {
+		claims := idp.LogoutClaims(testClientID, "user-1", "sid-1")
+		claims["nonce"] = nonce
+		_, err := authService.ParseLogoutToken(context.Background(), idp.Sign(t, claims))
+		if !errors.Is(err, services.ErrLogoutTokenNonce) {
+			t.Errorf("nonce %v: got %v, want ErrLogoutTokenNonce", nonce, err)
+		}
+	}
+}
+
+func TestParseLogoutTokenMalformed(t *testing.T) {
+	authService, _ := newTestAuthService(t)
+
+	_, err := authService.ParseLogoutToken(context.Background(), "not-a-jwt")
+	if !errors.Is(err, services.ErrLogoutTokenMalformed) {
+		t.Fatalf("got %v, want ErrLogoutTokenMalformed", err)
+	}
+}
+
+func TestParseLogoutTokenUnknownKidRefetchesJWKS(t *testing.T) {
+	authService, idp := newTestAuthService(t)
+	ctx := context.Background()
+
+	if _, err := authService.ParseLogoutToken(ctx, idp.Sign(t, idp.LogoutClaims(testClientID, "user-1", ""))); err != nil {
+		t.Fatalf("ParseLogoutToken before rotation: %v", err)
+	}
+	fetched := idp.JWKSRequests()
+
+	idp.RotateKey(t)
+	if _, err := authService.ParseLogoutToken(ctx, idp.Sign(t, idp.LogoutClaims(testClientID, "user-1", ""))); err != nil {
+		t.Fatalf("ParseLogoutToken after rotation: %v", err)
+	}
+	if got := idp.JWKSRequests(); got <= fetched {
+		t.Errorf("JWKS fetched %d times after rotation, want more than %d", got, fetched)
+	}
+}
+
+func TestParseLogoutTokenReplay(t *testing.T) {
+	authService, idp := newTestAuthService(t)
+	ctx := context.Background()
+
+	raw := idp.Sign(t, idp.LogoutClaims(testClientID, "user-1", "sid-1"))
+	if _, err := authService.ParseLogoutToken(ctx, raw); err != nil {
+		t.Fatalf("first ParseLogoutToken: %v", err)
+	}
+	if _, err := authService.ParseLogoutToken(ctx, raw); !errors.Is(err, services.ErrLogoutTokenReplayed) {
+		t.Fatalf("second ParseLogoutToken: got %v, want ErrLogoutTokenReplayed", err)
+	}
+}
+
+func TestVerifyAccessToken(t *testing.T) {
+	authService, idp := newTestAuthService(t)
+
+	idToken := idp.AccessClaims("user-1", testClientID)
+	idToken["typ"] = "ID"
+	refreshToken := idp.AccessClaims("user-1", testClientID)
+	refreshToken["typ"] = "Refresh"
+
+	tests := []struct {
+		name    string
+		claims  map[string]interface{}
+		wantErr bool
+	}{
+		{"access token with audience", idp.AccessClaims("user-1", "account", testClientID), false},
+		{"access token without audience", idp.AccessClaims("user-1", "account"), true},
+		{"ID token for the client", idToken, true},
+		{"refresh token", refreshToken, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := authService.VerifyAccessToken(context.Background(), idp.Sign(t, tt.claims))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("VerifyAccessToken accepted the token")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("VerifyAccessToken: %v", err)
+			}
+			if claims["sub"] != "user-1" {
+				t.Errorf("sub %v; want user-1", claims["sub"])
+			}
+		})
+	}
+}
+
+func TestVerifyAccessTokenRejectsIDToken(t *testing.T) {
+	authService, idp := newTestAuthService(t)
+
+	claims := idp.AccessClaims("user-1", testClientID)
+	claims["typ"] = "ID"
+	_, err := authService.VerifyAccessToken(context.Background(), idp.Sign(t, claims))
+	if !errors.Is(err, services.ErrNotAccessToken) {
+		t.Fatalf("got %v, want ErrNotAccessToken", err)
+	}
+}
+
+func TestEndSessionURL(t *testing.T) {
+	authService, idp := newTestAuthService(t)
+	const redirect = "http://localhost:8081/auth/logout/callback"
+
+	tests := []struct {
+		name        string
+		idTokenHint string
+	}{
+		{"with ID token hint", "id-token"},
+		{"without ID token hint", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			endSessionURL, err := url.Parse(authService.EndSessionURL(tt.idTokenHint, redirect, "state-1"))
+			if err != nil {
+				t.Fatalf("parse end-session URL: %v", err)
+			}
+			if got := endSessionURL.Scheme + "://" + endSessionURL.Host + endSessionURL.Path; got != idp.Issuer()+"/protocol/openid-connect/logout" {
+				t.Errorf("endpoint %s; want the discovered end_session_endpoint", got)
+			}
+
+			query := endSessionURL.Query()
+			want := map[string]string{
+				"post_logout_redirect_uri": redirect,
+				"client_id":                testClientID,
+				"state":                    "state-1",
+			}
+			for key, value := range want {
+				if query.Get(key) != value {
+					t.Errorf("%s = %q; want %q", key, query.Get(key), value)
+				}
+			}
+			if hint, present := query["id_token_hint"]; present != (tt.idTokenHint != "") || (present && hint[0] != tt.idTokenHint) {
+				t.Errorf("id_token_hint = %v; want %q", hint, tt.idTokenHint)
+			}
+		})
+	}
+}
+
+func TestRevokeToken(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int    // revocation endpoint failure status, or 0
+		body    string // and response body
+		wantErr string
+	}{
+		{"revoked", 0, "", ""},
+		{"OAuth error response", http.StatusBadRequest, `{"error":"invalid_request","error_description":"bad token"}`, "revocation failed (400): invalid_request: bad token"},
+		{"error without description", http.StatusUnauthorized, `{"error":"invalid_client"}`, "revocation failed (401): invalid_client: "},
+		{"non-JSON error", http.StatusServiceUnavailable, "upstream unavailable", "revocation failed with status 503"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			authService, idp := newTestAuthService(t)
+			if tt.status != 0 {
+				idp.FailRevocations(tt.status, tt.body)
+			}
+
+			err := authService.RevokeToken(context.Background(), "refresh-token", "refresh_token")
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("RevokeToken: %v", err)
+				}
+				if revoked := idp.RevokedTokens(); len(revoked) != 1 || revoked[0] != "refresh-token" {
+					t.Errorf("revoked %v; want [refresh-token]", revoked)
+				}
+				return
+			}
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("RevokeToken error %v; want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRevokeTokensReportsEachToken(t *testing.T) {
+	authService, idp := newTestAuthService(t)
+	idp.FailRevocations(http.StatusBadRequest, `{"error":"invalid_request"}`)
+
+	results := authService.RevokeTokens(context.Background(), &models.TokenSet{AccessToken: "access", RefreshToken: "refresh"})
+	if len(results) != 2 || results[0].TokenTypeHint != "refresh_token" || results[1].TokenTypeHint != "access_token" {
+		t.Fatalf("results %+v; want the refresh token, then the access token", results)
+	}
+	for _, result := range results {
+		if result.Revoked || result.Error == "" {
+			t.Errorf("%s: revoked %v, error %q; want a reported failure", result.TokenTypeHint, result.Revoked, result.Error)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package services
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"keycloak-logout-backend-go/metrics"
+)
+
+const (
+	// publishTimeout bounds a single event bus publish
+	publishTimeout = 5 * time.Second
+
+	// Backoff between attempts to publish logout targets
+	logoutRetryMinBackoff = 100 * time.Millisecond
+	logoutRetryMaxBackoff = 5 * time.Second
+)
+
+// Dispatcher publishes session events on the event bus from a background
+// goroutine, so that callers such as the back-channel logout endpoint never
+// wait for the bus or for subscribers. Events queue up to a fixed bound;
+// when the queue is full new events are dropped.
+type Dispatcher struct {
+	eventBus EventBus
+	fallback func(BusEvent)
+	queue    chan BusEvent
+
+	mu      sync.RWMutex
+	closed  bool
+	done    chan struct{}
+	abandon chan struct{} // closed when Close gives up waiting
+}
+
+// NewDispatcher starts a dispatcher that queues up to size events. If a
+// session event cannot be published, fallback delivers it locally. Logout
+// targets are only useful to the other replicas, so they are kept and
+// retried until they are published instead.
+func NewDispatcher(eventBus EventBus, size int, fallback func(BusEvent)) *Dispatcher {
+	d := &Dispatcher{
+		eventBus: eventBus,
+		fallback: fallback,
+		queue:    make(chan BusEvent, size),
+		done:     make(chan struct{}),
+		abandon:  make(chan struct{}),
+	}
+	go d.run()
+	return d
+}
+
+// Enqueue queues an event for publishing without blocking and reports
+// whether it was accepted
+func (d *Dispatcher) Enqueue(event BusEvent) bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if d.closed {
+		return false
+	}
+	select {
+	case d.queue <- event:
+		return true
+	default:
+		metrics.DispatchDropped.Inc()
+		return false
+	}
+}
+
+// Depth returns the number of events waiting to be published
+func (d *Dispatcher) Depth() int {
+	return len(d.queue)
+}
+
+// Close stops accepting events and waits until the queued ones, including
+// logout targets still being retried, have been published or ctx ends. Once
+// ctx ends the remaining logout targets are abandoned.
+func (d *Dispatcher) Close(ctx context.Context) error {
+	d.mu.Lock()
+	if !d.closed {
+		d.closed = true
+		close(d.queue)
+	}
+	d.mu.Unlock()
+
+	select {
+	case <-d.done:
+		return nil
+	case <-ctx.Done():
+		d.mu.Lock()
+		select {
+		case <-d.abandon:
+		default:
+			close(d.abandon)
+		}
+		d.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// run publishes queued events until the queue is closed and drained and no
+// logout target is waiting for a retry. Logout targets that fail to publish
+// are retried in order with backoff while other events keep flowing.
+func (d *Dispatcher) run() {
+	defer close(d.done)
+
+	queue := d.queue
+	var pending []BusEvent
+	var retry <-chan time.Time
+	backoff := logoutRetryMinBackoff
+
+	for queue != nil || len(pending) > 0 {
+		select {
+		case event, ok := <-queue:
+			if !ok {
+				queue = nil
+				continue
+			}
+			if event.Logout != nil && len(pending) > 0 {
+				pending = append(pending, event)
+				continue
+			}
+			if err := d.publish(event); err != nil {
+				if event.Logout == nil {
+					slog.Warn("event bus publish failed, delivering locally only", "error", err)
+					d.fallback(event)
+					continue
+				}
+				slog.Warn("event bus publish failed, retrying logout", "error", err, "retry_in", backoff)
+				pending = append(pending, event)
+				retry = time.After(backoff)
+			}
+
+		case <-retry:
+			for len(pending) > 0 {
+				if err := d.publish(pending[0]); err != nil {
+					break
+				}
+				pending = pending[1:]
+			}
+			if len(pending) == 0 {
+				retry = nil
+				backoff = logoutRetryMinBackoff
+				continue
+			}
+			backoff = min(backoff*2, logoutRetryMaxBackoff)
+			slog.Warn("event bus still unavailable, retrying logout", "pending", len(pending), "retry_in", backoff)
+			retry = time.After(backoff)
+
+		case <-d.abandon:
+			if len(pending) > 0 {
+				slog.Error("event bus unavailable at shutdown, logouts not sent to other replicas", "pending", len(pending))
+			}
+			return
+		}
+	}
+}
+
+// publish sends one event on the bus
+func (d *Dispatcher) publish(event BusEvent) error {
+	ctx, cancel := context.WithTimeout(context.Background(), publishTimeout)
+	defer cancel()
+	return d.eventBus.Publish(ctx, event)
+}
@@ -0,0 +1,253 @@
+package services_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"keycloak-logout-backend-go/metrics"
+	"keycloak-logout-backend-go/models"
+	"keycloak-logout-backend-go/services"
+)
+
+// flakyBus is an EventBus that fails its first failures publishes, or every
+// publish if failures is negative
+type flakyBus struct {
+	mu        sync.Mutex
+	failures  int
+	published []services.BusEvent
+}
+
+func (b *flakyBus) Publish(ctx context.Context, event services.BusEvent) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.failures != 0 {
+		if b.failures > 0 {
+			b.failures--
+		}
+		return errors.New("bus unavailable")
+	}
+	b.published = append(b.published, event)
+	return nil
+}
+
+func (b *flakyBus) Subscribe(handler func(services.BusEvent)) {}
+
+func (b *flakyBus) HasPeers() bool { return true }
+
+func (b *flakyBus) Close() error { return nil }
+
+// publishedIDs returns the IDs of published session events and the session
+// IDs of published logout targets, in order
+func (b *flakyBus) publishedIDs() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var ids []string
+	for _, event := range b.published {
+		if event.Logout != nil {
+			ids = append(ids, event.Logout.SessionID)
+		} else {
+			ids = append(ids, event.ID)
+		}
+	}
+	return ids
+}
+
+// blockingBus is an EventBus whose publishes wait until release is closed
+type blockingBus struct {
+	flakyBus
+	started chan struct{}
+	release chan struct{}
+}
+
+func newBlockingBus() *blockingBus {
+	return &blockingBus{started: make(chan struct{}, 1), release: make(chan struct{})}
+}
+
+func (b *blockingBus) Publish(ctx context.Context, event services.BusEvent) error {
+	select {
+	case b.started <- struct{}{}:
+	default:
+	}
+	<-b.release
+	return b.flakyBus.Publish(ctx, event)
+}
+
+// fallbackRecorder collects events the dispatcher delivers locally
+type fallbackRecorder struct {
+	mu     sync.Mutex
+	events []services.BusEvent
+}
+
+func (r *fallbackRecorder) deliver(event services.BusEvent) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, event)
+}
+
+func (r *fallbackRecorder) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.events)
+}
+
+func logoutEvent(sessionID string) services.BusEvent {
+	return services.BusEvent{Logout: &services.LogoutTarget{SessionID: sessionID, Reason: services.ReasonAdminLogout}}
+}
+
+func sessionEvent(id string) services.BusEvent {
+	return services.BusEvent{UserID: "user-1", SessionEvent: models.SessionEvent{ID: id, Type: services.EventSessionInvalidated}}
+}
+
+func closeDispatcher(t *testing.T, d *services.Dispatcher, timeout time.Duration) error {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return d.Close(ctx)
+}
+
+func TestDispatcherRetriesLogoutTargets(t *testing.T) {
+	bus := &flakyBus{failures: 3}
+	fallback := &fallbackRecorder{}
+	d := services.NewDispatcher(bus, 16, fallback.deliver)
+
+	d.Enqueue(logoutEvent("s1"))
+	d.Enqueue(logoutEvent("s2"))
+
+	waitFor(t, 5*time.Second, "logout targets to be published", func() bool { return len(bus.publishedIDs()) == 2 })
+	if got := bus.publishedIDs(); !equalStrings(got, []string{"s1", "s2"}) {
+		t.Errorf("published %v; want [s1 s2] in order", got)
+	}
+	if fallback.count() != 0 {
+		t.Errorf("%d logout targets delivered locally only; want 0", fallback.count())
+	}
+	if err := closeDispatcher(t, d, time.Second); err != nil {
+		t.Errorf("Close: %v", err)
+	}
+}
+
+func TestDispatcherFallsBackForSessionEvents(t *testing.T) {
+	bus := &flakyBus{failures: -1}
+	fallback := &fallbackRecorder{}
+	d := services.NewDispatcher(bus, 16, fallback.deliver)
+
+	d.Enqueue(sessionEvent("e1"))
+	waitFor(t, 5*time.Second, "local delivery", func() bool { return fallback.count() == 1 })
+	if err := closeDispatcher(t, d, time.Second); err != nil {
+		t.Errorf("Close: %v", err)
+	}
+}
+
+func TestDispatcherRetriesLogoutWhileEventsFlow(t *testing.T) {
+	bus := &flakyBus{failures: 1}
+	fallback := &fallbackRecorder{}
+	d := services.NewDispatcher(bus, 16, fallback.deliver)
+
+	// The logout fails once and waits for its retry; the session event
+	// behind it is published meanwhile
+	d.Enqueue(logoutEvent("s1"))
+	d.Enqueue(sessionEvent("e1"))
+
+	waitFor(t, 5*time.Second, "both events to be published", func() bool { return len(bus.publishedIDs()) == 2 })
+	if got := bus.publishedIDs(); !equalStrings(got, []string{"e1", "s1"}) {
+		t.Errorf("published %v; want [e1 s1]", got)
+	}
+	closeDispatcher(t, d, time.Second)
+}
+
+func TestDispatcherCloseAbandonsUnpublishableLogouts(t *testing.T) {
+	bus := &flakyBus{failures: -1}
+	fallback := &fallbackRecorder{}
+	d := services.NewDispatcher(bus, 16, fallback.deliver)
+
+	d.Enqueue(logoutEvent("s1"))
+	if err := closeDispatcher(t, d, 200*time.Millisecond); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Close: got %v, want DeadlineExceeded", err)
+	}
+	if fallback.count() != 0 {
+		t.Error("logout target delivered locally only")
+	}
+}
+
+func TestEndSessionsReachesOtherReplicasAfterBusRecovers(t *testing.T) {
+	bus := &flakyBus{failures: 2}
+	s := newTestSessionServiceOn(t, services.NewMemorySessionStore(), bus)
+
+	s.EndSessions(services.LogoutTarget{SID: "sid-1", Reason: services.ReasonBackchannelLogout})
+
+	waitFor(t, 5*time.Second, "logout target to be published", func() bool {
+		bus.mu.Lock()
+		defer bus.mu.Unlock()
+		for _, event := range bus.published {
+			if event.Logout != nil && event.Logout.SID == "sid-1" {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+func TestDispatcherDropsEventsWhenQueueIsFull(t *testing.T) {
+	bus := newBlockingBus()
+	d := services.NewDispatcher(bus, 2, (&fallbackRecorder{}).deliver)
+	dropped := testutil.ToFloat64(metrics.DispatchDropped)
+
+	// e1 is taken off the queue and blocks in Publish; e2 and e3 fill it
+	d.Enqueue(sessionEvent("e1"))
+	<-bus.started
+	for _, id := range []string{"e2", "e3"} {
+		if !d.Enqueue(sessionEvent(id)) {
+			t.Fatalf("Enqueue(%s) rejected below the queue bound", id)
+		}
+	}
+	if d.Enqueue(sessionEvent("e4")) {
+		t.Error("Enqueue accepted an event into a full queue")
+	}
+	if got := testutil.ToFloat64(metrics.DispatchDropped) - dropped; got != 1 {
+		t.Errorf("dispatch_dropped_total grew by %v; want 1", got)
+	}
+	if d.Depth() != 2 {
+		t.Errorf("depth %d; want 2", d.Depth())
+	}
+
+	close(bus.release)
+	if err := closeDispatcher(t, d, time.Second); err != nil {
+		t.Errorf("Close: %v", err)
+	}
+	if got := bus.publishedIDs(); !equalStrings(got, []string{"e1", "e2", "e3"}) {
+		t.Errorf("published %v; want [e1 e2 e3]", got)
+	}
+}
+
+func TestDispatcherCloseDrainsQueue(t *testing.T) {
+	bus := newBlockingBus()
+	d := services.NewDispatcher(bus, 16, (&fallbackRecorder{}).deliver)
+
+	d.Enqueue(sessionEvent("e1"))
+	<-bus.started
+	d.Enqueue(logoutEvent("s1"))
+	d.Enqueue(sessionEvent("e2"))
+
+	closed := make(chan error, 1)
+	go func() { closed <- closeDispatcher(t, d, 5*time.Second) }()
+	select {
+	case err := <-closed:
+		t.Fatalf("Close returned %v before the queue was drained", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(bus.release)
+	if err := <-closed; err != nil {
+		t.Errorf("Close: %v", err)
+	}
+	if got := bus.publishedIDs(); !equalStrings(got, []string{"e1", "s1", "e2"}) {
+		t.Errorf("published %v; want [e1 s1 e2]", got)
+	}
+	if d.Enqueue(sessionEvent("late")) {
+		t.Error("Enqueue accepted an event after Close")
+	}
+}
@@ -0,0 +1,109 @@
+package services
+
+import (
+	"context"
+	"sync"
+
+	"keycloak-logout-backend-go/models"
+)
+
+// Session event types carried on the event bus
+const (
+	EventSessionInvalidated = "session_invalidated"
+	EventSessionExpired     = "session_expired"
+)
+
+// EventServerShutdown tells local subscribers that this replica is shutting
+// down and they should reconnect. It is never published on the bus.
+const EventServerShutdown = "server_shutdown"
+
+// Reasons reported with session events
+const (
+	ReasonBackchannelLogout  = "backchannel_logout"
+	ReasonFrontchannelLogout = "frontchannel_logout"
+	ReasonAdminLogout        = "admin_logout"
+	ReasonRefreshRevoked     = "refresh_token_revoked"
+	ReasonIdleTimeout        = "idle_timeout"
+	ReasonMaxLifetime        = "max_lifetime"
+)
+
+// LogoutTarget selects the sessions a logout ends: those matching the sub
+// and/or sid of a logout token, or a single session ID, which with a sid
+// is only ended if it is bound to that sid. Replicas do not
+// share session stores, so targets are published for every replica to apply
+// to its own store.
+type LogoutTarget struct {
+	Subject   string `json:"sub,omitempty"`
+	SID       string `json:"sid,omitempty"`
+	SessionID string `json:"sessionId,omitempty"`
+	Reason    string `json:"reason"`
+	// Origin is the replica that published the target and has already
+	// applied it
+	Origin string `json:"origin"`
+}
+
+// BusEvent is a message shared between replicas. It is either a session
+// notification, recorded in the event log selected by the user ID, or, when
+// Logout is set, a request to end the matching sessions.
+type BusEvent struct {
+	UserID string        `json:"userId"`
+	Logout *LogoutTarget `json:"logout,omitempty"`
+	models.SessionEvent
+}
+
+// EventBus publishes session events to every replica, including the
+// publishing one. Each replica subscribes once and delivers the events it
+// receives to its local subscribers.
+type EventBus interface {
+	// Publish sends an event to all subscribers of all replicas
+	Publish(ctx context.Context, event BusEvent) error
+	// Subscribe registers a handler for events received by this replica
+	Subscribe(handler func(BusEvent))
+	// HasPeers reports whether published events may reach other replicas
+	HasPeers() bool
+	// Close stops the bus
+	Close() error
+}
+
+// LocalEventBus is an in-process EventBus for single-replica deployments
+type LocalEventBus struct {
+	mu       sync.RWMutex
+	handlers []func(BusEvent)
+}
+
+// NewLocalEventBus creates an in-process event bus
+func NewLocalEventBus() *LocalEventBus {
+	return &LocalEventBus{}
+}
+
+// Publish implements EventBus by calling every handler synchronously
+func (l *LocalEventBus) Publish(ctx context.Context, event BusEvent) error {
+	l.mu.RLock()
+	handlers := append([]func(BusEvent){}, l.handlers...)
+	l.mu.RUnlock()
+
+	for _, handler := range handlers {
+		handler(event)
+	}
+	return nil
+}
+
+// Subscribe implements EventBus
+func (l *LocalEventBus) Subscribe(handler func(BusEvent)) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.handlers = append(l.handlers, handler)
+}
+
+// HasPeers implements EventBus. Only several session services sharing the
+// bus in one process are peers.
+func (l *LocalEventBus) HasPeers() bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return len(l.handlers) > 1
+}
+
+// Close implements EventBus
+func (l *LocalEventBus) Close() error {
+	return nil
+}
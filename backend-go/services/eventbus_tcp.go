@@ -0,0 +1,400 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	tcpBusMaxLine      = 64 * 1024
+	tcpBusWriteTimeout = 5 * time.Second
+	tcpBusDialTimeout  = 5 * time.Second
+	tcpBusMaxBackoff   = 30 * time.Second
+	tcpBrokerQueueSize = 256
+
+	// tcpBusFrameMaxAge bounds the clock difference between replicas and
+	// how long a captured frame can be replayed
+	tcpBusFrameMaxAge = time.Minute
+)
+
+var (
+	// ErrEventBusDisconnected is returned by Publish while the bus has no
+	// connection to its broker
+	ErrEventBusDisconnected = errors.New("event bus is not connected")
+	// ErrEventBusSecretRequired is returned when a TCP bus or broker is
+	// created without a shared secret
+	ErrEventBusSecretRequired = errors.New("event bus secret is required")
+
+	errFrameMalformed = errors.New("malformed frame")
+	errFrameSignature = errors.New("invalid frame signature")
+	errFrameExpired   = errors.New("frame timestamp outside the allowed window")
+)
+
+// frameSigner authenticates bus frames with an HMAC-SHA256 over a shared
+// secret. A frame is one line: "<unix seconds> <base64url MAC> <JSON event>".
+// The MAC covers the timestamp and the event, so frames cannot be forged or
+// altered, and old frames are rejected.
+type frameSigner struct {
+	key []byte
+}
+
+func newFrameSigner(secret string) (frameSigner, error) {
+	if secret == "" {
+		return frameSigner{}, ErrEventBusSecretRequired
+	}
+	return frameSigner{key: []byte(secret)}, nil
+}
+
+func (f frameSigner) mac(timestamp, payload []byte) []byte {
+	h := hmac.New(sha256.New, f.key)
+	h.Write(timestamp)
+	h.Write([]byte{' '})
+	h.Write(payload)
+	return h.Sum(nil)
+}
+
+// seal returns the signed frame for payload, newline included
+func (f frameSigner) seal(payload []byte, now time.Time) []byte {
+	timestamp := []byte(strconv.FormatInt(now.Unix(), 10))
+	mac := base64.RawURLEncoding.EncodeToString(f.mac(timestamp, payload))
+
+	frame := make([]byte, 0, len(timestamp)+len(mac)+len(payload)+3)
+	frame = append(frame, timestamp...)
+	frame = append(frame, ' ')
+	frame = append(frame, mac...)
+	frame = append(frame, ' ')
+	frame = append(frame, payload...)
+	return append(frame, '\n')
+}
+
+// open verifies a frame line (without the newline) and returns its payload
+func (f frameSigner) open(line []byte, now time.Time) ([]byte, error) {
+	parts := bytes.SplitN(line, []byte{' '}, 3)
+	if len(parts) != 3 {
+		return nil, errFrameMalformed
+	}
+	timestamp, encodedMAC, payload := parts[0], parts[1], parts[2]
+
+	mac, err := base64.RawURLEncoding.DecodeString(string(encodedMAC))
+	if err != nil {
+		return nil, errFrameMalformed
+	}
+	if !hmac.Equal(mac, f.mac(timestamp, payload)) {
+		return nil, errFrameSignature
+	}
+	seconds, err := strconv.ParseInt(string(timestamp), 10, 64)
+	if err != nil {
+		return nil, errFrameMalformed
+	}
+	if age := now.Sub(time.Unix(seconds, 0)); age > tcpBusFrameMaxAge || age < -tcpBusFrameMaxAge {
+		return nil, errFrameExpired
+	}
+	return payload, nil
+}
+
+// TCPBroker relays newline-delimited JSON events between TCP event bus
+// clients. Every frame a client sends is forwarded to all connected clients,
+// the sender included, if it is signed with the shared secret. It can run
+// standalone or embedded in one replica.
+type TCPBroker struct {
+	listener net.Listener
+	signer   frameSigner
+	mu       sync.Mutex
+	conns    map[*brokerConn]struct{}
+	closed   bool
+}
+
+// brokerConn is a client connection with its outgoing queue
+type brokerConn struct {
+	conn net.Conn
+	out  chan []byte
+}
+
+// NewTCPBroker starts a broker listening on addr (e.g. ":7070") that only
+// relays frames signed with secret
+func NewTCPBroker(addr, secret string) (*TCPBroker, error) {
+	signer, err := newFrameSigner(secret)
+	if err != nil {
+		return nil, err
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start event broker: %w", err)
+	}
+
+	b := &TCPBroker{
+		listener: listener,
+		signer:   signer,
+		conns:    make(map[*brokerConn]struct{}),
+	}
+	go b.acceptLoop()
+	return b, nil
+}
+
+// Addr returns the address the broker listens on
+func (b *TCPBroker) Addr() net.Addr {
+	return b.listener.Addr()
+}
+
+// Close stops the broker and disconnects all clients
+func (b *TCPBroker) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return nil
+	}
+	b.closed = true
+	for c := range b.conns {
+		b.dropLocked(c)
+	}
+	return b.listener.Close()
+}
+
+func (b *TCPBroker) acceptLoop() {
+	for {
+		conn, err := b.listener.Accept()
+		if err != nil {
+			b.mu.Lock()
+			closed := b.closed
+			b.mu.Unlock()
+			if closed {
+				return
+			}
+			slog.Warn("event broker: accept failed", "error", err)
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+
+		c := &brokerConn{conn: conn, out: make(chan []byte, tcpBrokerQueueSize)}
+		b.mu.Lock()
+		if b.closed {
+			b.mu.Unlock()
+			conn.Close()
+			return
+		}
+		b.conns[c] = struct{}{}
+		b.mu.Unlock()
+
+		go b.writeLoop(c)
+		go b.readLoop(c)
+	}
+}
+
+func (b *TCPBroker) readLoop(c *brokerConn) {
+	defer b.drop(c)
+
+	scanner := bufio.NewScanner(c.conn)
+	scanner.Buffer(make([]byte, 4096), tcpBusMaxLine)
+	for scanner.Scan() {
+		if _, err := b.signer.open(scanner.Bytes(), time.Now()); err != nil {
+			slog.Warn("event broker: dropping unauthenticated frame", "remote", c.conn.RemoteAddr().String(), "error", err)
+			continue
+		}
+		line := append(append([]byte{}, scanner.Bytes()...), '\n')
+		b.broadcast(line)
+	}
+}
+
+func (b *TCPBroker) writeLoop(c *brokerConn) {
+	for line := range c.out {
+		c.conn.SetWriteDeadline(time.Now().Add(tcpBusWriteTimeout))
+		if _, err := c.conn.Write(line); err != nil {
+			b.drop(c)
+			return
+		}
+	}
+}
+
+// broadcast queues a line for every client. A client whose queue is full is
+// disconnected so that it cannot hold up the others; it will reconnect.
+func (b *TCPBroker) broadcast(line []byte) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for c := range b.conns {
+		select {
+		case c.out <- line:
+		default:
+			slog.Warn("event broker: client too slow, disconnecting", "remote", c.conn.RemoteAddr().String())
+			b.dropLocked(c)
+		}
+	}
+}
+
+func (b *TCPBroker) drop(c *brokerConn) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.dropLocked(c)
+}
+
+// dropLocked disconnects a client. Callers must hold mu.
+func (b *TCPBroker) dropLocked(c *brokerConn) {
+	if _, exists := b.conns[c]; !exists {
+		return
+	}
+	delete(b.conns, c)
+	close(c.out)
+	c.conn.Close()
+}
+
+// TCPEventBus is an EventBus client that exchanges events through a
+// TCPBroker. Frames are signed with a shared secret, and received frames
+// that fail verification are dropped. It reconnects with backoff when the
+// connection drops.
+type TCPEventBus struct {
+	addr       string
+	signer     frameSigner
+	connMu     sync.Mutex
+	conn       net.Conn
+	handlersMu sync.RWMutex
+	handlers   []func(BusEvent)
+	done       chan struct{}
+	closeOnce  sync.Once
+}
+
+// NewTCPEventBus creates a bus client that connects to the broker at addr
+// and signs and verifies frames with secret
+func NewTCPEventBus(addr, secret string) (*TCPEventBus, error) {
+	signer, err := newFrameSigner(secret)
+	if err != nil {
+		return nil, err
+	}
+	b := &TCPEventBus{
+		addr:   addr,
+		signer: signer,
+		done:   make(chan struct{}),
+	}
+	go b.run()
+	return b, nil
+}
+
+// Publish implements EventBus
+func (b *TCPEventBus) Publish(ctx context.Context, event BusEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to encode event: %w", err)
+	}
+
+	b.connMu.Lock()
+	defer b.connMu.Unlock()
+
+	if b.conn == nil {
+		return ErrEventBusDisconnected
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(tcpBusWriteTimeout)
+	}
+	b.conn.SetWriteDeadline(deadline)
+	if _, err := b.conn.Write(b.signer.seal(data, time.Now())); err != nil {
+		b.conn.Close()
+		return fmt.Errorf("failed to publish event: %w", err)
+	}
+	return nil
+}
+
+// Subscribe implements EventBus
+func (b *TCPEventBus) Subscribe(handler func(BusEvent)) {
+	b.handlersMu.Lock()
+	defer b.handlersMu.Unlock()
+	b.handlers = append(b.handlers, handler)
+}
+
+// HasPeers implements EventBus. Other replicas may be connected to the
+// broker at any time.
+func (b *TCPEventBus) HasPeers() bool {
+	return true
+}
+
+// Close implements EventBus
+func (b *TCPEventBus) Close() error {
+	b.closeOnce.Do(func() {
+		close(b.done)
+		b.connMu.Lock()
+		if b.conn != nil {
+			b.conn.Close()
+		}
+		b.connMu.Unlock()
+	})
+	return nil
+}
+
+// run keeps a connection to the broker open until the bus is closed
+func (b *TCPEventBus) run() {
+	backoff := 100 * time.Millisecond
+	for {
+		conn, err := net.DialTimeout("tcp", b.addr, tcpBusDialTimeout)
+		if err == nil {
+			slog.Info("event bus: connected to broker", "addr", b.addr)
+			backoff = 100 * time.Millisecond
+
+			b.connMu.Lock()
+			select {
+			case <-b.done:
+				b.connMu.Unlock()
+				conn.Close()
+				return
+			default:
+			}
+			b.conn = conn
+			b.connMu.Unlock()
+
+			b.readLoop(conn)
+
+			b.connMu.Lock()
+			b.conn = nil
+			b.connMu.Unlock()
+			conn.Close()
+			slog.Warn("event bus: disconnected from broker", "addr", b.addr)
+		} else {
+			slog.Warn("event bus: cannot reach broker", "addr", b.addr, "error", err)
+		}
+
+		select {
+		case <-b.done:
+			return
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > tcpBusMaxBackoff {
+			backoff = tcpBusMaxBackoff
+		}
+	}
+}
+
+// readLoop dispatches events from the broker until the connection fails
+func (b *TCPEventBus) readLoop(conn net.Conn) {
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 4096), tcpBusMaxLine)
+	for scanner.Scan() {
+		payload, err := b.signer.open(scanner.Bytes(), time.Now())
+		if err != nil {
+			slog.Warn("event bus: dropping unauthenticated frame", "error", err)
+			continue
+		}
+		var event BusEvent
+		if err := json.Unmarshal(payload, &event); err != nil {
+			slog.Warn("event bus: skipping malformed event", "error", err)
+			continue
+		}
+
+		b.handlersMu.RLock()
+		handlers := append([]func(BusEvent){}, b.handlers...)
+		b.handlersMu.RUnlock()
+		for _, handler := range handlers {
+			handler(event)
+		}
+	}
+}
@@ -0,0 +1,226 @@
+package services_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"keycloak-logout-backend-go/models"
+	"keycloak-logout-backend-go/services"
+)
+
+// testBusSecret is the shared secret of the test brokers and clients
+const testBusSecret = "bus-secret"
+
+// waitFor polls cond until it holds or timeout passes
+func waitFor(t *testing.T, timeout time.Duration, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// recorder collects the events a bus client receives
+type recorder struct {
+	mu     sync.Mutex
+	events []services.BusEvent
+}
+
+func (r *recorder) handle(event services.BusEvent) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, event)
+}
+
+// has reports whether an event with the given ID was received
+func (r *recorder) has(id string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, event := range r.events {
+		if event.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// newTCPBus connects a bus client to addr and waits until it is connected
+func newTCPBus(t *testing.T, addr string) (*services.TCPEventBus, *recorder) {
+	t.Helper()
+	bus, rec := newTCPBusWithoutProbe(t, addr)
+	waitConnected(t, bus)
+	return bus, rec
+}
+
+// waitConnected waits until the bus can publish, using a probe event
+func waitConnected(t *testing.T, bus *services.TCPEventBus) {
+	t.Helper()
+	waitFor(t, 5*time.Second, "bus connection", func() bool {
+		return bus.Publish(context.Background(), services.BusEvent{SessionEvent: models.SessionEvent{Type: "probe"}}) == nil
+	})
+}
+
+// publish sends a session event with the given ID
+func publish(t *testing.T, bus *services.TCPEventBus, id string) {
+	t.Helper()
+	event := services.BusEvent{
+		UserID:       "user-1",
+		SessionEvent: models.SessionEvent{ID: id, Type: services.EventSessionInvalidated, SessionID: "session-1"},
+	}
+	if err := bus.Publish(context.Background(), event); err != nil {
+		t.Fatalf("Publish(%s): %v", id, err)
+	}
+}
+
+func startBroker(t *testing.T, addr string) *services.TCPBroker {
+	t.Helper()
+	broker, err := services.NewTCPBroker(addr, testBusSecret)
+	if err != nil {
+		t.Fatalf("NewTCPBroker(%s): %v", addr, err)
+	}
+	return broker
+}
+
+func TestTCPEventBusDelivery(t *testing.T) {
+	broker := startBroker(t, "127.0.0.1:0")
+	defer broker.Close()
+
+	a, recA := newTCPBus(t, broker.Addr().String())
+	_, recB := newTCPBus(t, broker.Addr().String())
+
+	publish(t, a, "event-1")
+	waitFor(t, 5*time.Second, "delivery to the other client", func() bool { return recB.has("event-1") })
+	waitFor(t, 5*time.Second, "delivery to the sender", func() bool { return recA.has("event-1") })
+}
+
+func TestTCPEventBusReconnectsAfterBrokerRestart(t *testing.T) {
+	broker := startBroker(t, "127.0.0.1:0")
+	addr := broker.Addr().String()
+
+	a, _ := newTCPBus(t, addr)
+	b, recB := newTCPBus(t, addr)
+
+	broker.Close()
+	waitFor(t, 5*time.Second, "disconnect", func() bool {
+		return a.Publish(context.Background(), services.BusEvent{}) == services.ErrEventBusDisconnected
+	})
+
+	broker = startBroker(t, addr)
+	defer broker.Close()
+	waitConnected(t, a)
+	waitConnected(t, b)
+
+	publish(t, a, "after-restart")
+	waitFor(t, 5*time.Second, "delivery after reconnect", func() bool { return recB.has("after-restart") })
+}
+
+func TestLogoutReachesSessionOnOtherReplica(t *testing.T) {
+	broker := startBroker(t, "127.0.0.1:0")
+	defer broker.Close()
+
+	busA, _ := newTCPBus(t, broker.Addr().String())
+	busB, _ := newTCPBus(t, broker.Addr().String())
+
+	// Replicas keep separate stores; the session lives on B only
+	replicaA := newTestSessionServiceOn(t, services.NewMemorySessionStore(), busA)
+	replicaB := newTestSessionServiceOn(t, services.NewMemorySessionStore(), busB)
+	addTestSession(t, replicaB, "session-1", "user-1", "sid-1")
+	sub := replicaB.Subscribe(services.TransportSSE, "user-1", "session-1")
+
+	// Keycloak's back-channel call lands on A
+	if removed := replicaA.EndSessions(services.LogoutTarget{SID: "sid-1", Reason: services.ReasonBackchannelLogout}); len(removed) != 0 {
+		t.Fatalf("replica A removed %d sessions; it holds none", len(removed))
+	}
+
+	waitFor(t, 5*time.Second, "session removal on replica B", func() bool {
+		_, exists := replicaB.GetSession(context.Background(), "session-1")
+		return !exists
+	})
+	select {
+	case <-sub.Ready():
+		events := sub.Drain()
+		if len(events) != 1 || events[0].Type != services.EventSessionInvalidated || events[0].Reason != services.ReasonBackchannelLogout {
+			t.Errorf("subscriber on B got %+v; want one session_invalidated", events)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("subscriber on replica B was not notified")
+	}
+}
+
+func TestTCPEventBusRequiresSecret(t *testing.T) {
+	if _, err := services.NewTCPBroker("127.0.0.1:0", ""); err != services.ErrEventBusSecretRequired {
+		t.Errorf("NewTCPBroker without secret: got %v, want ErrEventBusSecretRequired", err)
+	}
+	if _, err := services.NewTCPEventBus("127.0.0.1:0", ""); err != services.ErrEventBusSecretRequired {
+		t.Errorf("NewTCPEventBus without secret: got %v, want ErrEventBusSecretRequired", err)
+	}
+}
+
+func TestTCPEventBusIgnoresUnauthenticatedFrames(t *testing.T) {
+	broker := startBroker(t, "127.0.0.1:0")
+	defer broker.Close()
+
+	busA, _ := newTCPBus(t, broker.Addr().String())
+	busB, recB := newTCPBus(t, broker.Addr().String())
+	replicaB := newTestSessionServiceOn(t, services.NewMemorySessionStore(), busB)
+	addTestSession(t, replicaB, "session-1", "user-1", "sid-1")
+
+	// An attacker reaching the broker port sends a logout target and a
+	// session event, unsigned and signed with the wrong secret
+	attacker, err := net.Dial("tcp", broker.Addr().String())
+	if err != nil {
+		t.Fatalf("dial broker: %v", err)
+	}
+	defer attacker.Close()
+	logout, _ := json.Marshal(services.BusEvent{Logout: &services.LogoutTarget{Subject: "user-1", Reason: services.ReasonAdminLogout}})
+	forged, _ := json.Marshal(services.BusEvent{UserID: "user-1", SessionEvent: models.SessionEvent{ID: "forged", Type: services.EventSessionInvalidated}})
+	fmt.Fprintf(attacker, "%s\n", logout)
+	fmt.Fprintf(attacker, "%d bm90LWEtbWFj %s\n", time.Now().Unix(), forged)
+
+	// A forged frame reaching a client directly is dropped as well
+	forgedBroker, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer forgedBroker.Close()
+	_, recC := newTCPBusWithoutProbe(t, forgedBroker.Addr().String())
+	conn, err := forgedBroker.Accept()
+	if err != nil {
+		t.Fatalf("accept: %v", err)
+	}
+	defer conn.Close()
+	fmt.Fprintf(conn, "%s\n", forged)
+
+	publish(t, busA, "genuine")
+	waitFor(t, 5*time.Second, "delivery of a signed event", func() bool { return recB.has("genuine") })
+	time.Sleep(200 * time.Millisecond)
+
+	if recB.has("forged") || recC.has("forged") {
+		t.Error("forged session event was delivered")
+	}
+	if _, exists := replicaB.GetSession(context.Background(), "session-1"); !exists {
+		t.Error("unsigned logout target ended a session")
+	}
+}
+
+// newTCPBusWithoutProbe connects a bus client to addr without waiting for
+// the connection, for brokers that do not relay probes
+func newTCPBusWithoutProbe(t *testing.T, addr string) (*services.TCPEventBus, *recorder) {
+	t.Helper()
+	bus, err := services.NewTCPEventBus(addr, testBusSecret)
+	if err != nil {
+		t.Fatalf("NewTCPEventBus: %v", err)
+	}
+	t.Cleanup(func() { bus.Close() })
+	rec := &recorder{}
+	bus.Subscribe(rec.handle)
+	return bus, rec
+}
@@ -0,0 +1,135 @@
+package services
+
+import (
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"keycloak-logout-backend-go/models"
+)
+
+// Limits of the per-user event log kept for Last-Event-ID replay
+const (
+	eventLogSize      = 50
+	eventLogRetention = 15 * time.Minute
+)
+
+// lastEventID is the most recently issued event ID
+var lastEventID atomic.Int64
+
+// newEventID returns an event ID that is larger than any issued before by
+// this process and, being time-based, roughly ordered across replicas
+func newEventID(now time.Time) string {
+	for {
+		last := lastEventID.Load()
+		next := now.UnixNano()
+		if next <= last {
+			next = last + 1
+		}
+		if lastEventID.CompareAndSwap(last, next) {
+			return strconv.FormatInt(next, 10)
+		}
+	}
+}
+
+// eventAfter reports whether event ID id comes after lastID. Unparseable
+// IDs never match, so a bogus Last-Event-ID replays nothing.
+func eventAfter(id, lastID string) bool {
+	a, errA := strconv.ParseInt(id, 10, 64)
+	b, errB := strconv.ParseInt(lastID, 10, 64)
+	return errA == nil && errB == nil && a > b
+}
+
+// EventLog keeps the most recent session events of each user so that SSE
+// clients reconnecting with Last-Event-ID receive what they missed. Each
+// user's log holds at most size events, and events older than retention
+// are discarded.
+type EventLog struct {
+	mu        sync.Mutex
+	size      int
+	retention time.Duration
+	users     map[string][]models.SessionEvent // user ID -> events, oldest first
+	sessions  map[string]string                // session ID -> user ID of logged events
+}
+
+// NewEventLog creates an event log with the given per-user bound
+func NewEventLog(size int, retention time.Duration) *EventLog {
+	return &EventLog{
+		size:      size,
+		retention: retention,
+		users:     make(map[string][]models.SessionEvent),
+		sessions:  make(map[string]string),
+	}
+}
+
+// Append records an event of a user
+func (l *EventLog) Append(userID string, event models.SessionEvent) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.pruneLocked(time.Now())
+	events := append(l.users[userID], event)
+	if len(events) > l.size {
+		dropped := events[:len(events)-l.size]
+		events = append([]models.SessionEvent(nil), events[len(events)-l.size:]...)
+		l.unindexLocked(dropped, events)
+	}
+	l.users[userID] = events
+	l.sessions[event.SessionID] = userID
+}
+
+// Since returns the logged events of a session issued after lastEventID,
+// oldest first
+func (l *EventLog) Since(sessionID, lastEventID string) []models.SessionEvent {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.pruneLocked(time.Now())
+	userID, ok := l.sessions[sessionID]
+	if !ok {
+		return nil
+	}
+	var missed []models.SessionEvent
+	for _, event := range l.users[userID] {
+		if event.SessionID == sessionID && eventAfter(event.ID, lastEventID) {
+			missed = append(missed, event)
+		}
+	}
+	return missed
+}
+
+// pruneLocked drops events past the retention period.
+// Callers must hold mu.
+func (l *EventLog) pruneLocked(now time.Time) {
+	cutoff := now.Add(-l.retention)
+	for userID, events := range l.users {
+		keep := 0
+		for keep < len(events) && events[keep].Timestamp.Before(cutoff) {
+			keep++
+		}
+		if keep == 0 {
+			continue
+		}
+		l.unindexLocked(events[:keep], events[keep:])
+		if keep == len(events) {
+			delete(l.users, userID)
+		} else {
+			l.users[userID] = events[keep:]
+		}
+	}
+}
+
+// unindexLocked removes the sessions of dropped events from the session
+// index unless kept still holds events of them. Callers must hold mu.
+func (l *EventLog) unindexLocked(dropped, kept []models.SessionEvent) {
+	live := make(map[string]struct{}, len(kept))
+	for _, event := range kept {
+		live[event.SessionID] = struct{}{}
+	}
+	for _, event := range dropped {
+		if _, ok := live[event.SessionID]; !ok {
+			delete(l.sessions, event.SessionID)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package services
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"keycloak-logout-backend-go/models"
+)
+
+func TestEventAfter(t *testing.T) {
+	tests := []struct {
+		id, lastID string
+		want       bool
+	}{
+		{"2", "1", true},
+		{"1", "1", false},
+		{"1", "2", false},
+		{"10", "9", true}, // numeric, not lexical
+		{"2", "bogus", false},
+		{"bogus", "1", false},
+		{"2", "", false},
+	}
+	for _, tt := range tests {
+		if got := eventAfter(tt.id, tt.lastID); got != tt.want {
+			t.Errorf("eventAfter(%q, %q) = %v; want %v", tt.id, tt.lastID, got, tt.want)
+		}
+	}
+}
+
+func TestNewEventIDIncreases(t *testing.T) {
+	now := time.Now()
+	first := newEventID(now)
+	// The same clock reading still yields a larger ID
+	if second := newEventID(now); !eventAfter(second, first) {
+		t.Errorf("second ID %s does not come after %s", second, first)
+	}
+}
+
+// loggedEvent returns an event of session with a numeric ID
+func loggedEvent(id int, sessionID string, at time.Time) models.SessionEvent {
+	return models.SessionEvent{ID: strconv.Itoa(id), Type: EventSessionInvalidated, SessionID: sessionID, Timestamp: at}
+}
+
+// eventIDs returns the IDs of events, in order
+func eventIDs(events []models.SessionEvent) []string {
+	var ids []string
+	for _, event := range events {
+		ids = append(ids, event.ID)
+	}
+	return ids
+}
+
+func TestEventLogSince(t *testing.T) {
+	log := NewEventLog(10, time.Minute)
+	now := time.Now()
+	log.Append("user-1", loggedEvent(1, "s1", now))
+	log.Append("user-1", loggedEvent(2, "s2", now))
+	log.Append("user-1", loggedEvent(3, "s1", now))
+	log.Append("user-2", loggedEvent(4, "s3", now))
+
+	tests := []struct {
+		name        string
+		sessionID   string
+		lastEventID string
+		want        []string
+	}{
+		{"events of the session after the ID", "s1", "1", []string{"3"}},
+		{"all events after an earlier ID", "s1", "0", []string{"1", "3"}},
+		{"nothing after the latest ID", "s1", "3", nil},
+		{"unknown event ID", "s1", "bogus", nil},
+		{"unknown session", "s9", "0", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := eventIDs(log.Since(tt.sessionID, tt.lastEventID))
+			if len(got) != len(tt.want) {
+				t.Fatalf("Since(%s, %s) = %v; want %v", tt.sessionID, tt.lastEventID, got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("Since(%s, %s) = %v; want %v", tt.sessionID, tt.lastEventID, got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestEventLogEvictsBeyondSize(t *testing.T) {
+	log := NewEventLog(2, time.Minute)
+	now := time.Now()
+	log.Append("user-1", loggedEvent(1, "s1", now))
+	log.Append("user-1", loggedEvent(2, "s2", now))
+	log.Append("user-1", loggedEvent(3, "s2", now))
+
+	// The only event of s1 was evicted, so its session is forgotten
+	if got := log.Since("s1", "0"); got != nil {
+		t.Errorf("Since(s1) = %v; want nothing after eviction", eventIDs(got))
+	}
+	if _, indexed := log.sessions["s1"]; indexed {
+		t.Error("evicted session is still indexed")
+	}
+	// An evicted last event ID still replays the retained events after it
+	if got := eventIDs(log.Since("s2", "1")); len(got) != 2 || got[0] != "2" || got[1] != "3" {
+		t.Errorf("Since(s2, 1) = %v; want [2 3]", got)
+	}
+}
+
+func TestEventLogDropsExpiredEvents(t *testing.T) {
+	log := NewEventLog(10, time.Minute)
+	now := time.Now()
+	log.Append("user-1", loggedEvent(1, "s1", now.Add(-2*time.Minute)))
+	log.Append("user-2", loggedEvent(2, "s2", now))
+
+	if got := log.Since("s1", "0"); got != nil {
+		t.Errorf("Since(s1) = %v; want nothing past retention", eventIDs(got))
+	}
+	if _, kept := log.users["user-1"]; kept {
+		t.Error("log of a user with only expired events was kept")
+	}
+	if got := eventIDs(log.Since("s2", "0")); len(got) != 1 || got[0] != "2" {
+		t.Errorf("Since(s2) = %v; want [2]", got)
+	}
+}
@@ -0,0 +1,123 @@
+package services
+
+import (
+	"context"
+	"log/slog"
+)
+
+// ForcedLogoutResult describes the outcome of a forced logout. Removed counts
+// the sessions ended on this replica; Forwarded is set when the session was
+// not held here and the logout was only sent to the other replicas.
+type ForcedLogoutResult struct {
+	Removed   int              `json:"removed"`
+	Forwarded bool             `json:"forwarded,omitempty"`
+	IdPLogout *IdPLogoutResult `json:"idpLogout,omitempty"`
+}
+
+// IdPLogoutResult describes the outcome of the IdP-side part of a forced
+// logout
+type IdPLogoutResult struct {
+	Success    bool   `json:"success"`
+	EndedCount int    `json:"endedSessions,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// ForcedLogoutService ends sessions on behalf of an administrator: locally,
+// with an SSE invalidation, and optionally at the IdP so the user cannot log
+// straight back in via SSO
+type ForcedLogoutService struct {
+	sessionService *SessionService
+	idp            IdPSessionTerminator
+}
+
+// NewForcedLogoutService creates a forced logout service. idp may be nil if
+// IdP-side logout is not configured.
+func NewForcedLogoutService(sessionSvc *SessionService, idp IdPSessionTerminator) *ForcedLogoutService {
+	return &ForcedLogoutService{
+		sessionService: sessionSvc,
+		idp:            idp,
+	}
+}
+
+// LogoutSession ends a single session on whichever replica holds it. If this
+// replica does not, the logout is forwarded and the result marked Forwarded;
+// the session's IdP session is then unknown here, so IdP logout is skipped
+// and reported as ErrSessionNotLocal. Without other replicas it returns
+// ErrSessionNotFound instead.
+func (f *ForcedLogoutService) LogoutSession(ctx context.Context, sessionID string, idpLogout bool) (*ForcedLogoutResult, error) {
+	removed := f.sessionService.EndSessions(LogoutTarget{SessionID: sessionID, Reason: ReasonAdminLogout})
+	if len(removed) == 0 {
+		if !f.sessionService.HasPeers() {
+			return nil, ErrSessionNotFound
+		}
+		slog.InfoContext(ctx, "forced logout of session forwarded to other replicas", "session_id", sessionID)
+		result := &ForcedLogoutResult{Forwarded: true}
+		if idpLogout {
+			result.IdPLogout = &IdPLogoutResult{Error: ErrSessionNotLocal.Error()}
+		}
+		return result, nil
+	}
+	session := removed[0]
+	slog.InfoContext(ctx, "forced logout of session", "session_id", sessionID, "target_user_id", session.User.ID)
+
+	result := &ForcedLogoutResult{Removed: 1}
+	if idpLogout {
+		result.IdPLogout = f.idpLogout(ctx, func() (int, error) {
+			// Without a sid the IdP session cannot be singled out
+			if session.IdPSessionID == "" {
+				return f.logoutUserAtIdP(ctx, session.User.ID)
+			}
+			return 1, f.idp.DeleteSession(ctx, session.IdPSessionID)
+		})
+	}
+	return result, nil
+}
+
+// LogoutUser ends every session of a user on every replica. Removed counts
+// the sessions held by this replica.
+func (f *ForcedLogoutService) LogoutUser(ctx context.Context, userID string, idpLogout bool) (*ForcedLogoutResult, error) {
+	removed := f.sessionService.EndSessions(LogoutTarget{Subject: userID, Reason: ReasonAdminLogout})
+	slog.InfoContext(ctx, "forced logout of user", "target_user_id", userID, "sessions", len(removed))
+
+	result := &ForcedLogoutResult{Removed: len(removed)}
+	if idpLogout {
+		result.IdPLogout = f.idpLogout(ctx, func() (int, error) {
+			return f.logoutUserAtIdP(ctx, userID)
+		})
+	}
+	return result, nil
+}
+
+// logoutUserAtIdP ends all IdP sessions of a user, returning how many the IdP
+// reported beforehand when it can list them
+func (f *ForcedLogoutService) logoutUserAtIdP(ctx context.Context, userID string) (int, error) {
+	count := 0
+	if lister, ok := f.idp.(IdPSessionLister); ok {
+		if sessions, err := lister.ListUserSessions(ctx, userID); err == nil {
+			count = len(sessions)
+		} else {
+			slog.WarnContext(ctx, "forced logout: listing IdP sessions failed", "target_user_id", userID, "error", err)
+		}
+	}
+	return count, f.idp.LogoutUser(ctx, userID)
+}
+
+// idpLogout runs an IdP-side logout and describes its outcome
+func (f *ForcedLogoutService) idpLogout(ctx context.Context, logout func() (int, error)) *IdPLogoutResult {
+	if f.idp == nil {
+		return &IdPLogoutResult{Error: ErrIdPLogoutNotConfigured.Error()}
+	}
+
+	ended, err := logout()
+	if err != nil {
+		slog.WarnContext(ctx, "forced logout: IdP logout failed", "error", err)
+		return &IdPLogoutResult{Error: err.Error()}
+	}
+	return &IdPLogoutResult{Success: true, EndedCount: ended}
+}
+
+// Compile-time checks that the Keycloak client can end and list IdP sessions
+var (
+	_ IdPSessionTerminator = (*KeycloakAdminClient)(nil)
+	_ IdPSessionLister     = (*KeycloakAdminClient)(nil)
+)
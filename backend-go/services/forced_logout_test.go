@@ -0,0 +1,207 @@
+package services_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"keycloak-logout-backend-go/services"
+)
+
+// fakeTerminator records IdP logouts
+type fakeTerminator struct {
+	mu           sync.Mutex
+	loggedOut    []string // user IDs
+	deletedSIDs  []string
+	err          error
+	userSessions []services.KeycloakUserSession
+}
+
+func (f *fakeTerminator) LogoutUser(ctx context.Context, userID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.loggedOut = append(f.loggedOut, userID)
+	return f.err
+}
+
+func (f *fakeTerminator) DeleteSession(ctx context.Context, sid string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.deletedSIDs = append(f.deletedSIDs, sid)
+	return f.err
+}
+
+// fakeListingTerminator is a fakeTerminator that can also list sessions
+type fakeListingTerminator struct {
+	*fakeTerminator
+}
+
+func (f fakeListingTerminator) ListUserSessions(ctx context.Context, userID string) ([]services.KeycloakUserSession, error) {
+	return f.userSessions, nil
+}
+
+func TestForcedLogoutSessionWithTerminator(t *testing.T) {
+	s := newTestSessionService(t)
+	addTestSession(t, s, "s1", "user-1", "sid-1")
+	idp := &fakeTerminator{}
+	forced := services.NewForcedLogoutService(s, idp)
+
+	result, err := forced.LogoutSession(context.Background(), "s1", true)
+	if err != nil {
+		t.Fatalf("LogoutSession: %v", err)
+	}
+	if result.Removed != 1 || result.Forwarded {
+		t.Errorf("result %+v; want one local session removed", result)
+	}
+	if result.IdPLogout == nil || !result.IdPLogout.Success {
+		t.Errorf("IdP logout %+v; want success", result.IdPLogout)
+	}
+	if !equalStrings(idp.deletedSIDs, []string{"sid-1"}) || len(idp.loggedOut) != 0 {
+		t.Errorf("IdP calls: deleted %v, logged out %v; want only sid-1 deleted", idp.deletedSIDs, idp.loggedOut)
+	}
+	if _, exists := s.GetSession(context.Background(), "s1"); exists {
+		t.Error("session still exists")
+	}
+}
+
+func TestForcedLogoutSessionWithoutSID(t *testing.T) {
+	s := newTestSessionService(t)
+	addTestSession(t, s, "s1", "user-1", "")
+	idp := &fakeTerminator{}
+	forced := services.NewForcedLogoutService(s, idp)
+
+	if _, err := forced.LogoutSession(context.Background(), "s1", true); err != nil {
+		t.Fatalf("LogoutSession: %v", err)
+	}
+	// Without a sid the whole user is logged out at the IdP
+	if !equalStrings(idp.loggedOut, []string{"user-1"}) {
+		t.Errorf("IdP user logouts %v; want [user-1]", idp.loggedOut)
+	}
+}
+
+func TestForcedLogoutSessionIdPFailure(t *testing.T) {
+	s := newTestSessionService(t)
+	addTestSession(t, s, "s1", "user-1", "sid-1")
+	forced := services.NewForcedLogoutService(s, &fakeTerminator{err: errors.New("admin request failed with status 403")})
+
+	result, err := forced.LogoutSession(context.Background(), "s1", true)
+	if err != nil {
+		t.Fatalf("LogoutSession: %v", err)
+	}
+	if result.Removed != 1 || result.IdPLogout == nil || result.IdPLogout.Success || result.IdPLogout.Error == "" {
+		t.Errorf("result %+v, IdP %+v; want the local logout done and the IdP error reported", result, result.IdPLogout)
+	}
+}
+
+func TestForcedLogoutWithoutTerminator(t *testing.T) {
+	s := newTestSessionService(t)
+	addTestSession(t, s, "s1", "user-1", "sid-1")
+	forced := services.NewForcedLogoutService(s, nil)
+
+	result, err := forced.LogoutSession(context.Background(), "s1", true)
+	if err != nil {
+		t.Fatalf("LogoutSession: %v", err)
+	}
+	if result.Removed != 1 {
+		t.Errorf("removed %d; want 1", result.Removed)
+	}
+	if result.IdPLogout == nil || result.IdPLogout.Error != services.ErrIdPLogoutNotConfigured.Error() {
+		t.Errorf("IdP logout %+v; want not configured", result.IdPLogout)
+	}
+}
+
+func TestForcedLogoutWithoutIdPLogout(t *testing.T) {
+	s := newTestSessionService(t)
+	addTestSession(t, s, "s1", "user-1", "sid-1")
+	idp := &fakeTerminator{}
+	forced := services.NewForcedLogoutService(s, idp)
+
+	result, err := forced.LogoutSession(context.Background(), "s1", false)
+	if err != nil {
+		t.Fatalf("LogoutSession: %v", err)
+	}
+	if result.IdPLogout != nil || len(idp.deletedSIDs) != 0 || len(idp.loggedOut) != 0 {
+		t.Errorf("IdP contacted without idpLogout: result %+v", result.IdPLogout)
+	}
+}
+
+func TestForcedLogoutSessionOnOtherReplica(t *testing.T) {
+	bus := services.NewLocalEventBus()
+	s := newTestSessionServiceOn(t, services.NewMemorySessionStore(), bus)
+	other := newTestSessionServiceOn(t, services.NewMemorySessionStore(), bus)
+	addTestSession(t, other, "elsewhere", "user-1", "sid-1")
+	idp := &fakeTerminator{}
+	forced := services.NewForcedLogoutService(s, idp)
+
+	result, err := forced.LogoutSession(context.Background(), "elsewhere", true)
+	if err != nil {
+		t.Fatalf("LogoutSession: %v", err)
+	}
+	if !result.Forwarded || result.Removed != 0 {
+		t.Errorf("result %+v; want forwarded", result)
+	}
+	// The sid is unknown here, so the IdP session is left alone
+	if result.IdPLogout == nil || result.IdPLogout.Error != services.ErrSessionNotLocal.Error() {
+		t.Errorf("IdP logout %+v; want session not local", result.IdPLogout)
+	}
+	if len(idp.deletedSIDs) != 0 || len(idp.loggedOut) != 0 {
+		t.Errorf("IdP contacted for a session held elsewhere: deleted %v, logged out %v", idp.deletedSIDs, idp.loggedOut)
+	}
+	waitFor(t, 5*time.Second, "session removal on the other replica", func() bool {
+		_, exists := other.GetSession(context.Background(), "elsewhere")
+		return !exists
+	})
+}
+
+func TestForcedLogoutUnknownSessionWithoutPeers(t *testing.T) {
+	s := newTestSessionService(t)
+	forced := services.NewForcedLogoutService(s, &fakeTerminator{})
+
+	if _, err := forced.LogoutSession(context.Background(), "unknown", true); !errors.Is(err, services.ErrSessionNotFound) {
+		t.Fatalf("LogoutSession: got %v, want ErrSessionNotFound", err)
+	}
+}
+
+func TestForcedLogoutUser(t *testing.T) {
+	s := newTestSessionService(t)
+	addTestSession(t, s, "s1", "user-1", "sid-1")
+	addTestSession(t, s, "s2", "user-1", "sid-2")
+	addTestSession(t, s, "s3", "user-2", "sid-3")
+	idp := fakeListingTerminator{&fakeTerminator{
+		userSessions: []services.KeycloakUserSession{{ID: "sid-1"}, {ID: "sid-2"}, {ID: "sid-9"}},
+	}}
+	forced := services.NewForcedLogoutService(s, idp)
+
+	result, err := forced.LogoutUser(context.Background(), "user-1", true)
+	if err != nil {
+		t.Fatalf("LogoutUser: %v", err)
+	}
+	if result.Removed != 2 {
+		t.Errorf("removed %d; want 2", result.Removed)
+	}
+	if result.IdPLogout == nil || !result.IdPLogout.Success || result.IdPLogout.EndedCount != 3 {
+		t.Errorf("IdP logout %+v; want success ending 3 sessions", result.IdPLogout)
+	}
+	if !equalStrings(idp.loggedOut, []string{"user-1"}) {
+		t.Errorf("IdP user logouts %v; want [user-1]", idp.loggedOut)
+	}
+	if _, exists := s.GetSession(context.Background(), "s3"); !exists {
+		t.Error("session of another user was removed")
+	}
+}
+
+func TestForcedLogoutUserWithoutTerminator(t *testing.T) {
+	s := newTestSessionService(t)
+	addTestSession(t, s, "s1", "user-1", "sid-1")
+	forced := services.NewForcedLogoutService(s, nil)
+
+	result, err := forced.LogoutUser(context.Background(), "user-1", true)
+	if err != nil {
+		t.Fatalf("LogoutUser: %v", err)
+	}
+	if result.Removed != 1 || result.IdPLogout == nil || result.IdPLogout.Error != services.ErrIdPLogoutNotConfigured.Error() {
+		t.Errorf("result %+v, IdP %+v; want local logout and IdP not configured", result, result.IdPLogout)
+	}
+}
@@ -0,0 +1,29 @@
+package services
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrIdPLogoutNotConfigured is returned when an IdP-side logout is requested
+// but no IdPSessionTerminator is available
+var ErrIdPLogoutNotConfigured = errors.New("IdP logout is not configured")
+
+// ErrSessionNotLocal is reported for an IdP-side logout of a session held by
+// another replica, whose IdP session this replica cannot look up
+var ErrSessionNotLocal = errors.New("session is held by another replica; log out the user to end its IdP sessions")
+
+// IdPSessionTerminator ends sessions at the identity provider, so that a user
+// whose local session was killed cannot silently log back in via SSO
+type IdPSessionTerminator interface {
+	// LogoutUser ends every IdP session of a user
+	LogoutUser(ctx context.Context, userID string) error
+	// DeleteSession ends a single IdP session (the sid claim)
+	DeleteSession(ctx context.Context, sid string) error
+}
+
+// IdPSessionLister is implemented by terminators that can also list a user's
+// IdP sessions
+type IdPSessionLister interface {
+	ListUserSessions(ctx context.Context, userID string) ([]KeycloakUserSession, error)
+}
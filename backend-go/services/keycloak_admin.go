@@ -0,0 +1,96 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"golang.org/x/oauth2/clientcredentials"
+
+	"keycloak-logout-backend-go/config"
+)
+
+// KeycloakUserSession is a user session as reported by the Keycloak admin API
+type KeycloakUserSession struct {
+	ID         string            `json:"id"`
+	Username   string            `json:"username"`
+	UserID     string            `json:"userId"`
+	IPAddress  string            `json:"ipAddress"`
+	Start      int64             `json:"start"`
+	LastAccess int64             `json:"lastAccess"`
+	Clients    map[string]string `json:"clients"`
+}
+
+// KeycloakAdminClient calls the Keycloak admin REST API of the realm with a
+// client-credentials token. The client's service account needs the
+// realm-management manage-users role.
+type KeycloakAdminClient struct {
+	baseURL    string // e.g. https://keycloak/admin/realms/my-realm
+	httpClient *http.Client
+}
+
+// NewKeycloakAdminClient creates an admin API client for the configured realm
+func NewKeycloakAdminClient(cfg *config.Config) *KeycloakAdminClient {
+	credentials := &clientcredentials.Config{
+		ClientID:     cfg.AdminClientID,
+		ClientSecret: cfg.AdminClientSecret,
+		TokenURL:     cfg.GetIssuerURL() + "/protocol/openid-connect/token",
+	}
+
+	return &KeycloakAdminClient{
+		baseURL:    cfg.GetAdminAPIURL(),
+		httpClient: credentials.Client(context.Background()),
+	}
+}
+
+// ListUserSessions returns the Keycloak sessions of a user
+func (k *KeycloakAdminClient) ListUserSessions(ctx context.Context, userID string) ([]KeycloakUserSession, error) {
+	var sessions []KeycloakUserSession
+	if err := k.do(ctx, http.MethodGet, "/users/"+url.PathEscape(userID)+"/sessions", &sessions); err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+// LogoutUser ends every Keycloak session of a user
+func (k *KeycloakAdminClient) LogoutUser(ctx context.Context, userID string) error {
+	return k.do(ctx, http.MethodPost, "/users/"+url.PathEscape(userID)+"/logout", nil)
+}
+
+// DeleteSession ends a single Keycloak session
+func (k *KeycloakAdminClient) DeleteSession(ctx context.Context, sid string) error {
+	return k.do(ctx, http.MethodDelete, "/sessions/"+url.PathEscape(sid), nil)
+}
+
+// do sends an admin API request and decodes the JSON response into out,
+// if out is not nil
+func (k *KeycloakAdminClient) do(ctx context.Context, method, path string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, method, k.baseURL+path, nil)
+	if err != nil {
+		return fmt.Errorf("failed to build admin request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := k.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("admin request %s %s failed: %w", method, path, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("admin request %s %s failed with status %d: %s", method, path, resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode admin response: %w", err)
+	}
+	return nil
+}
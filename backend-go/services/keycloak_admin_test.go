@@ -0,0 +1,184 @@
+package services_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"keycloak-logout-backend-go/config"
+	"keycloak-logout-backend-go/services"
+)
+
+const (
+	adminClientID     = "admin-cli-client"
+	adminClientSecret = "admin-secret"
+	adminAccessToken  = "admin-access-token"
+)
+
+// fakeKeycloakAdmin is a stand-in for the Keycloak token endpoint and the
+// session endpoints of the admin REST API
+type fakeKeycloakAdmin struct {
+	server *httptest.Server
+
+	mu          sync.Mutex
+	tokenGrants int
+	requests    []string // "METHOD path" of admin requests
+	failStatus  int      // if set, admin requests fail with this status
+}
+
+func newFakeKeycloakAdmin(t *testing.T) *fakeKeycloakAdmin {
+	t.Helper()
+	f := &fakeKeycloakAdmin{}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/realms/test/protocol/openid-connect/token", f.handleToken)
+	mux.HandleFunc("/admin/realms/test/", f.handleAdmin)
+	f.server = httptest.NewServer(mux)
+	t.Cleanup(f.server.Close)
+	return f
+}
+
+// config returns an application config pointing the admin client at f
+func (f *fakeKeycloakAdmin) config() *config.Config {
+	return &config.Config{
+		KeycloakURL:       f.server.URL,
+		KeycloakRealm:     "test",
+		AdminClientID:     adminClientID,
+		AdminClientSecret: adminClientSecret,
+	}
+}
+
+func (f *fakeKeycloakAdmin) handleToken(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	id, secret, ok := r.BasicAuth()
+	if !ok {
+		id, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	if r.PostForm.Get("grant_type") != "client_credentials" || id != adminClientID || secret != adminClientSecret {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_client"})
+		return
+	}
+
+	f.mu.Lock()
+	f.tokenGrants++
+	f.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"access_token": adminAccessToken,
+		"token_type":   "Bearer",
+		"expires_in":   300,
+	})
+}
+
+func (f *fakeKeycloakAdmin) handleAdmin(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "Bearer "+adminAccessToken {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	path := strings.TrimPrefix(r.URL.Path, "/admin/realms/test")
+
+	f.mu.Lock()
+	f.requests = append(f.requests, r.Method+" "+path)
+	failStatus := f.failStatus
+	f.mu.Unlock()
+	if failStatus != 0 {
+		http.Error(w, `{"error":"forbidden"}`, failStatus)
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodGet && path == "/users/user-1/sessions":
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode([]services.KeycloakUserSession{
+			{ID: "sid-1", UserID: "user-1", Username: "alice"},
+			{ID: "sid-2", UserID: "user-1", Username: "alice"},
+		})
+	case r.Method == http.MethodPost && path == "/users/user-1/logout":
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodDelete && path == "/sessions/sid-1":
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// adminRequests returns the admin requests received so far
+func (f *fakeKeycloakAdmin) adminRequests() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string{}, f.requests...)
+}
+
+func TestKeycloakAdminClient(t *testing.T) {
+	fake := newFakeKeycloakAdmin(t)
+	client := services.NewKeycloakAdminClient(fake.config())
+	ctx := context.Background()
+
+	sessions, err := client.ListUserSessions(ctx, "user-1")
+	if err != nil {
+		t.Fatalf("ListUserSessions: %v", err)
+	}
+	if len(sessions) != 2 || sessions[0].ID != "sid-1" {
+		t.Errorf("ListUserSessions = %+v; want sid-1 and sid-2", sessions)
+	}
+	if err := client.LogoutUser(ctx, "user-1"); err != nil {
+		t.Errorf("LogoutUser: %v", err)
+	}
+	if err := client.DeleteSession(ctx, "sid-1"); err != nil {
+		t.Errorf("DeleteSession: %v", err)
+	}
+
+	want := []string{"GET /users/user-1/sessions", "POST /users/user-1/logout", "DELETE /sessions/sid-1"}
+	if got := fake.adminRequests(); !equalStrings(got, want) {
+		t.Errorf("admin requests %v; want %v", got, want)
+	}
+	// The client-credentials token is fetched once and reused
+	if fake.tokenGrants != 1 {
+		t.Errorf("token fetched %d times; want 1", fake.tokenGrants)
+	}
+}
+
+func TestKeycloakAdminClientErrorStatus(t *testing.T) {
+	fake := newFakeKeycloakAdmin(t)
+	fake.failStatus = http.StatusForbidden
+	client := services.NewKeycloakAdminClient(fake.config())
+	ctx := context.Background()
+
+	if _, err := client.ListUserSessions(ctx, "user-1"); err == nil || !strings.Contains(err.Error(), "403") {
+		t.Errorf("ListUserSessions error = %v; want status 403", err)
+	}
+	if err := client.LogoutUser(ctx, "user-1"); err == nil || !strings.Contains(err.Error(), "403") {
+		t.Errorf("LogoutUser error = %v; want status 403", err)
+	}
+	if err := client.DeleteSession(ctx, "sid-1"); err == nil || !strings.Contains(err.Error(), "403") {
+		t.Errorf("DeleteSession error = %v; want status 403", err)
+	}
+}
+
+func TestKeycloakAdminClientNotFound(t *testing.T) {
+	fake := newFakeKeycloakAdmin(t)
+	client := services.NewKeycloakAdminClient(fake.config())
+
+	if err := client.DeleteSession(context.Background(), "unknown"); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("DeleteSession(unknown) error = %v; want status 404", err)
+	}
+}
+
+func TestKeycloakAdminClientBadCredentials(t *testing.T) {
+	fake := newFakeKeycloakAdmin(t)
+	cfg := fake.config()
+	cfg.AdminClientSecret = "wrong"
+	client := services.NewKeycloakAdminClient(cfg)
+
+	if err := client.LogoutUser(context.Background(), "user-1"); err == nil {
+		t.Error("LogoutUser succeeded without a valid client-credentials token")
+	}
+	if got := fake.adminRequests(); len(got) != 0 {
+		t.Errorf("admin API called without a token: %v", got)
+	}
+}
@@ -0,0 +1,160 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"keycloak-logout-backend-go/config"
+	"keycloak-logout-backend-go/models"
+)
+
+// LogoutTokenError describes why a logout token was rejected. Code is a
+// stable identifier that is returned to the caller.
+type LogoutTokenError struct {
+	Code   string
+	Reason string
+	Err    error
+}
+
+// Logout token rejection reasons
+var (
+	ErrLogoutTokenMalformed = &LogoutTokenError{Code: "invalid_token_format", Reason: "malformed logout token"}
+	ErrLogoutTokenSignature = &LogoutTokenError{Code: "invalid_signature", Reason: "logout token signature verification failed"}
+	ErrLogoutTokenIssuer    = &LogoutTokenError{Code: "invalid_issuer", Reason: "logout token issuer does not match"}
+	ErrLogoutTokenAudience  = &LogoutTokenError{Code: "invalid_audience", Reason: "logout token audience does not contain the client ID"}
+	ErrLogoutTokenIssuedAt  = &LogoutTokenError{Code: "invalid_iat", Reason: "logout token iat is missing or in the future"}
+	ErrLogoutTokenTooOld    = &LogoutTokenError{Code: "token_too_old", Reason: "logout token is older than the allowed maximum age"}
+	ErrLogoutTokenExpired   = &LogoutTokenError{Code: "token_expired", Reason: "logout token has expired"}
+	ErrLogoutTokenEvents    = &LogoutTokenError{Code: "invalid_events", Reason: "logout token events claim is missing the back-channel logout event"}
+	ErrLogoutTokenSubject   = &LogoutTokenError{Code: "missing_sub_and_sid", Reason: "logout token contains neither sub nor sid"}
+	ErrLogoutTokenNonce     = &LogoutTokenError{Code: "nonce_present", Reason: "logout token must not contain a nonce"}
+	ErrLogoutTokenJTI       = &LogoutTokenError{Code: "missing_jti", Reason: "logout token has no jti"}
+	ErrLogoutTokenReplayed  = &LogoutTokenError{Code: "token_replayed", Reason: "logout token has already been used"}
+)
+
+func (e *LogoutTokenError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s: %v", e.Reason, e.Err)
+	}
+	return e.Reason
+}
+
+func (e *LogoutTokenError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether target is a LogoutTokenError with the same code, so the
+// exported sentinels can be matched with errors.Is
+func (e *LogoutTokenError) Is(target error) bool {
+	t, ok := target.(*LogoutTokenError)
+	return ok && t.Code == e.Code
+}
+
+// wrap returns a copy of the sentinel carrying the underlying cause
+func (e *LogoutTokenError) wrap(err error) *LogoutTokenError {
+	return &LogoutTokenError{Code: e.Code, Reason: e.Reason, Err: err}
+}
+
+// LogoutTokenValidator checks logout token claims as required by
+// OIDC Back-Channel Logout 1.0, section 2.6
+type LogoutTokenValidator struct {
+	Issuer    string
+	ClientID  string
+	ClockSkew time.Duration
+	MaxAge    time.Duration
+
+	// Now returns the current time; overridable for tests
+	Now func() time.Time
+}
+
+// NewLogoutTokenValidator creates a validator from the application config
+func NewLogoutTokenValidator(cfg *config.Config) *LogoutTokenValidator {
+	return &LogoutTokenValidator{
+		Issuer:    cfg.GetIssuerURL(),
+		ClientID:  cfg.ClientID,
+		ClockSkew: cfg.LogoutTokenClockSkew,
+		MaxAge:    cfg.LogoutTokenMaxAge,
+		Now:       time.Now,
+	}
+}
+
+// Validate returns a *LogoutTokenError describing the first violation found,
+// or nil if the token is acceptable
+func (v *LogoutTokenValidator) Validate(token *models.LogoutToken) error {
+	now := v.Now()
+
+	if token.Issuer != v.Issuer {
+		return ErrLogoutTokenIssuer.wrap(fmt.Errorf("got %q, want %q", token.Issuer, v.Issuer))
+	}
+
+	if !token.Audience.Contains(v.ClientID) {
+		return ErrLogoutTokenAudience.wrap(fmt.Errorf("got %v, want %q", []string(token.Audience), v.ClientID))
+	}
+
+	if token.IssuedAt == 0 {
+		return ErrLogoutTokenIssuedAt
+	}
+	issuedAt := time.Unix(token.IssuedAt, 0)
+	if issuedAt.After(now.Add(v.ClockSkew)) {
+		return ErrLogoutTokenIssuedAt.wrap(fmt.Errorf("issued at %s", issuedAt.Format(time.RFC3339)))
+	}
+	if v.MaxAge > 0 && now.Sub(issuedAt) > v.MaxAge+v.ClockSkew {
+		return ErrLogoutTokenTooOld.wrap(fmt.Errorf("issued at %s", issuedAt.Format(time.RFC3339)))
+	}
+
+	if token.ExpiresAt != 0 {
+		expiresAt := time.Unix(token.ExpiresAt, 0)
+		if now.After(expiresAt.Add(v.ClockSkew)) {
+			return ErrLogoutTokenExpired.wrap(fmt.Errorf("expired at %s", expiresAt.Format(time.RFC3339)))
+		}
+	}
+
+	event, ok := token.Events[models.BackchannelLogoutEvent]
+	if !ok {
+		return ErrLogoutTokenEvents
+	}
+	var eventValue map[string]interface{}
+	if err := json.Unmarshal(event, &eventValue); err != nil || eventValue == nil {
+		return ErrLogoutTokenEvents.wrap(fmt.Errorf("event member must be a JSON object"))
+	}
+
+	if token.Subject == "" && token.SessionID == "" {
+		return ErrLogoutTokenSubject
+	}
+
+	if token.Nonce != nil {
+		return ErrLogoutTokenNonce
+	}
+
+	if token.JTI == "" {
+		return ErrLogoutTokenJTI
+	}
+
+	return nil
+}
+
+// rememberForever is the replay cache expiry of a token that never stops
+// being accepted
+var rememberForever = time.Date(9999, time.December, 31, 0, 0, 0, 0, time.UTC)
+
+// AcceptedUntil returns the last moment at which the token would still pass
+// the age and expiry checks, which is how long its jti must be remembered.
+// With MaxAge 0 only exp bounds the token; without exp either it is
+// remembered forever.
+func (v *LogoutTokenValidator) AcceptedUntil(token *models.LogoutToken) time.Time {
+	var until time.Time
+	if v.MaxAge > 0 {
+		until = time.Unix(token.IssuedAt, 0).Add(v.MaxAge + v.ClockSkew)
+	}
+	if token.ExpiresAt != 0 {
+		expiry := time.Unix(token.ExpiresAt, 0).Add(v.ClockSkew)
+		if until.IsZero() || expiry.Before(until) {
+			until = expiry
+		}
+	}
+	if until.IsZero() {
+		return rememberForever
+	}
+	return until
+}
@@ -0,0 +1,152 @@
+package services_test
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"keycloak-logout-backend-go/models"
+	"keycloak-logout-backend-go/services"
+)
+
+const testIssuer = "https://keycloak.example.com/realms/test"
+
+var validatorNow = time.Unix(1700000000, 0)
+
+// newTestValidator returns a validator with a fixed clock
+func newTestValidator(maxAge time.Duration) *services.LogoutTokenValidator {
+	return &services.LogoutTokenValidator{
+		Issuer:    testIssuer,
+		ClientID:  testClientID,
+		ClockSkew: 30 * time.Second,
+		MaxAge:    maxAge,
+		Now:       func() time.Time { return validatorNow },
+	}
+}
+
+// validLogoutToken returns a token that passes validation at validatorNow
+func validLogoutToken() *models.LogoutToken {
+	return &models.LogoutToken{
+		Issuer:    testIssuer,
+		Subject:   "user-1",
+		Audience:  models.Audience{testClientID},
+		IssuedAt:  validatorNow.Unix(),
+		ExpiresAt: validatorNow.Add(time.Minute).Unix(),
+		JTI:       "jti-1",
+		SessionID: "sid-1",
+		Events: map[string]json.RawMessage{
+			models.BackchannelLogoutEvent: json.RawMessage(`{}`),
+		},
+	}
+}
+
+func TestLogoutTokenValidator(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(token *models.LogoutToken)
+		want   error
+	}{
+		{"valid", func(token *models.LogoutToken) {}, nil},
+		{"valid with sid only", func(token *models.LogoutToken) { token.Subject = "" }, nil},
+		{"valid with sub only", func(token *models.LogoutToken) { token.SessionID = "" }, nil},
+		{"valid without exp", func(token *models.LogoutToken) { token.ExpiresAt = 0 }, nil},
+		{"valid within clock skew", func(token *models.LogoutToken) { token.IssuedAt = validatorNow.Add(20 * time.Second).Unix() }, nil},
+		{"audience in array", func(token *models.LogoutToken) { token.Audience = models.Audience{"other", testClientID} }, nil},
+		{"wrong issuer", func(token *models.LogoutToken) { token.Issuer = "https://evil.example.com/realms/test" }, services.ErrLogoutTokenIssuer},
+		{"wrong audience", func(token *models.LogoutToken) { token.Audience = models.Audience{"other"} }, services.ErrLogoutTokenAudience},
+		{"missing iat", func(token *models.LogoutToken) { token.IssuedAt = 0 }, services.ErrLogoutTokenIssuedAt},
+		{"iat in the future", func(token *models.LogoutToken) { token.IssuedAt = validatorNow.Add(time.Minute).Unix() }, services.ErrLogoutTokenIssuedAt},
+		{"too old", func(token *models.LogoutToken) {
+			token.IssuedAt = validatorNow.Add(-3 * time.Minute).Unix()
+			token.ExpiresAt = 0
+		}, services.ErrLogoutTokenTooOld},
+		{"expired", func(token *models.LogoutToken) { token.ExpiresAt = validatorNow.Add(-time.Minute).Unix() }, services.ErrLogoutTokenExpired},
+		{"missing events", func(token *models.LogoutToken) { token.Events = nil }, services.ErrLogoutTokenEvents},
+		{"other event only", func(token *models.LogoutToken) {
+			token.Events = map[string]json.RawMessage{"http://example.com/other": json.RawMessage(`{}`)}
+		}, services.ErrLogoutTokenEvents},
+		{"event member not an object", func(token *models.LogoutToken) {
+			token.Events[models.BackchannelLogoutEvent] = json.RawMessage(`"yes"`)
+		}, services.ErrLogoutTokenEvents},
+		{"neither sub nor sid", func(token *models.LogoutToken) {
+			token.Subject = ""
+			token.SessionID = ""
+		}, services.ErrLogoutTokenSubject},
+		{"nonce present", func(token *models.LogoutToken) { token.Nonce = json.RawMessage(`"n-0S6_WzA2Mj"`) }, services.ErrLogoutTokenNonce},
+		{"null nonce", func(token *models.LogoutToken) { token.Nonce = json.RawMessage(`null`) }, services.ErrLogoutTokenNonce},
+		{"missing jti", func(token *models.LogoutToken) { token.JTI = "" }, services.ErrLogoutTokenJTI},
+	}
+
+	validator := newTestValidator(2 * time.Minute)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token := validLogoutToken()
+			tt.modify(token)
+
+			err := validator.Validate(token)
+			if tt.want == nil {
+				if err != nil {
+					t.Fatalf("Validate: %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("Validate: got %v, want %v", err, tt.want)
+			}
+			var tokenErr *services.LogoutTokenError
+			if !errors.As(err, &tokenErr) || tokenErr.Code == "" {
+				t.Errorf("Validate: %v is not a *LogoutTokenError with a code", err)
+			}
+		})
+	}
+}
+
+func TestLogoutTokenValidatorNoMaxAge(t *testing.T) {
+	token := validLogoutToken()
+	token.IssuedAt = validatorNow.Add(-24 * time.Hour).Unix()
+	token.ExpiresAt = 0
+
+	if err := newTestValidator(0).Validate(token); err != nil {
+		t.Fatalf("Validate with MaxAge 0: %v", err)
+	}
+}
+
+func TestLogoutTokenAcceptedUntil(t *testing.T) {
+	issuedAt := validatorNow
+	tests := []struct {
+		name      string
+		maxAge    time.Duration
+		expiresAt time.Time
+		want      time.Time
+	}{
+		{"max age", 2 * time.Minute, time.Time{}, issuedAt.Add(2*time.Minute + 30*time.Second)},
+		{"exp before max age", 2 * time.Minute, issuedAt.Add(time.Minute), issuedAt.Add(time.Minute + 30*time.Second)},
+		{"max age before exp", 2 * time.Minute, issuedAt.Add(time.Hour), issuedAt.Add(2*time.Minute + 30*time.Second)},
+		{"no max age uses exp", 0, issuedAt.Add(time.Hour), issuedAt.Add(time.Hour + 30*time.Second)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token := validLogoutToken()
+			token.IssuedAt = issuedAt.Unix()
+			token.ExpiresAt = 0
+			if !tt.expiresAt.IsZero() {
+				token.ExpiresAt = tt.expiresAt.Unix()
+			}
+			if got := newTestValidator(tt.maxAge).AcceptedUntil(token); !got.Equal(tt.want) {
+				t.Errorf("AcceptedUntil = %v; want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLogoutTokenAcceptedUntilWithoutAnyLimit(t *testing.T) {
+	token := validLogoutToken()
+	token.ExpiresAt = 0
+
+	// The token is accepted forever, so its jti must outlive any replay
+	if got := newTestValidator(0).AcceptedUntil(token); got.Before(validatorNow.Add(100 * 365 * 24 * time.Hour)) {
+		t.Errorf("AcceptedUntil = %v; want the jti remembered indefinitely", got)
+	}
+}
@@ -0,0 +1,436 @@
+// Package oidctest runs a stand-in Keycloak realm for tests. It serves OIDC
+// discovery, a JWKS endpoint, a token endpoint for the authorization code
+// (with PKCE) and refresh token grants and a token revocation endpoint, and
+// signs tokens with the realm's RSA keys:
+//
+//	idp := oidctest.NewProvider(t)
+//	authService, err := services.NewAuthService(idp.Config("my-client"), services.NewMemoryReplayCache())
+//	token := idp.Sign(t, idp.LogoutClaims("my-client", "user-1", "sid-1"))
+package oidctest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+
+	"keycloak-logout-backend-go/config"
+)
+
+// Realm is the name of the stand-in realm
+const Realm = "test"
+
+// ClientID is the client the token endpoint issues tokens to, matching the
+// audience of ID tokens it returns
+const ClientID = "cp-client"
+
+// Provider is a stand-in Keycloak realm
+type Provider struct {
+	Server *httptest.Server
+
+	mu            sync.Mutex
+	keys          []signingKey // published keys; the last one signs
+	jwksRequests  int
+	authCodes     map[string]authCode // unused authorization code -> login
+	refreshTokens map[string]string   // valid refresh token -> subject
+	refreshGrants int
+	badIDTokens   bool // sign refreshed ID tokens with an unpublished key
+	tokenSerial   int
+	revoked       []string // tokens revoked at the revocation endpoint
+	revokeStatus  int      // if set, revocation fails with this status
+	revokeBody    string   // and this response body
+}
+
+// authCode is a login authorized by Authorize, redeemable once
+type authCode struct {
+	sub, sid, nonce string
+	codeChallenge   string
+}
+
+// signingKey is an RSA key published in the JWKS under kid
+type signingKey struct {
+	kid string
+	key *rsa.PrivateKey
+}
+
+// NewProvider starts a realm with one signing key. The server is closed
+// when the test ends.
+func NewProvider(t *testing.T) *Provider {
+	t.Helper()
+	p := &Provider{authCodes: make(map[string]authCode), refreshTokens: make(map[string]string)}
+	p.keys = append(p.keys, newSigningKey(t))
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/realms/"+Realm+"/.well-known/openid-configuration", p.handleDiscovery)
+	mux.HandleFunc("/realms/"+Realm+"/protocol/openid-connect/certs", p.handleJWKS)
+	mux.HandleFunc("/realms/"+Realm+"/protocol/openid-connect/token", func(w http.ResponseWriter, r *http.Request) {
+		p.handleToken(t, w, r)
+	})
+	mux.HandleFunc("/realms/"+Realm+"/protocol/openid-connect/revoke", p.handleRevoke)
+	p.Server = httptest.NewServer(mux)
+	t.Cleanup(p.Server.Close)
+	return p
+}
+
+// Issuer returns the realm's issuer URL
+func (p *Provider) Issuer() string {
+	return p.Server.URL + "/realms/" + Realm
+}
+
+// Config returns an application config pointing at the realm for clientID
+func (p *Provider) Config(clientID string) *config.Config {
+	return &config.Config{
+		KeycloakURL:          p.Server.URL,
+		KeycloakRealm:        Realm,
+		ClientID:             clientID,
+		ClientSecret:         "secret",
+		Port:                 "8081",
+		FrontendURL:          "http://localhost:3000",
+		BearerAudience:       clientID,
+		LogoutTokenClockSkew: 30 * time.Second,
+		LogoutTokenMaxAge:    2 * time.Minute,
+	}
+}
+
+// RotateKey publishes a new signing key, which signs all later tokens, and
+// returns its kid. Earlier keys stay published.
+func (p *Provider) RotateKey(t *testing.T) string {
+	t.Helper()
+	key := newSigningKey(t)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.keys = append(p.keys, key)
+	return key.kid
+}
+
+// JWKSRequests returns how often the JWKS endpoint has been fetched
+func (p *Provider) JWKSRequests() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.jwksRequests
+}
+
+// Sign returns claims signed with the current realm key
+func (p *Provider) Sign(t *testing.T, claims jwt.MapClaims) string {
+	t.Helper()
+	p.mu.Lock()
+	key := p.keys[len(p.keys)-1]
+	p.mu.Unlock()
+	return sign(t, key.kid, key.key, claims)
+}
+
+// SignWithUnpublishedKey returns claims signed with a key that is not in the
+// JWKS but carries the kid of the current realm key, so the signature does
+// not verify
+func (p *Provider) SignWithUnpublishedKey(t *testing.T, claims jwt.MapClaims) string {
+	t.Helper()
+	p.mu.Lock()
+	kid := p.keys[len(p.keys)-1].kid
+	p.mu.Unlock()
+	return sign(t, kid, newSigningKey(t).key, claims)
+}
+
+// Authorize logs sub in with IdP session sid at the authorization URL the
+// client redirected to, and returns the authorization code. The ID token
+// issued for the code carries the URL's nonce, and redeeming the code
+// requires the verifier of its S256 code_challenge.
+func (p *Provider) Authorize(t *testing.T, authURL, sub, sid string) string {
+	t.Helper()
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatalf("parse authorization URL: %v", err)
+	}
+	query := u.Query()
+	if method := query.Get("code_challenge_method"); method != "S256" {
+		t.Fatalf("code_challenge_method %q; want S256", method)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.tokenSerial++
+	code := fmt.Sprintf("code-%d", p.tokenSerial)
+	p.authCodes[code] = authCode{sub: sub, sid: sid, nonce: query.Get("nonce"), codeChallenge: query.Get("code_challenge")}
+	return code
+}
+
+// IssueRefreshToken returns a refresh token for sub that the token endpoint
+// accepts once; each refresh rotates it
+func (p *Provider) IssueRefreshToken(sub string) string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.issueRefreshTokenLocked(sub)
+}
+
+func (p *Provider) issueRefreshTokenLocked(sub string) string {
+	p.tokenSerial++
+	token := fmt.Sprintf("refresh-%d", p.tokenSerial)
+	p.refreshTokens[token] = sub
+	return token
+}
+
+// RevokeRefreshTokens ends every IdP session, so that refreshes fail with
+// invalid_grant
+func (p *Provider) RevokeRefreshTokens() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.refreshTokens = make(map[string]string)
+}
+
+// RefreshGrants returns how many refresh token grants were requested
+func (p *Provider) RefreshGrants() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.refreshGrants
+}
+
+// SignRefreshedIDTokensWithUnpublishedKey makes later refresh grants return
+// ID tokens whose signature does not verify
+func (p *Provider) SignRefreshedIDTokensWithUnpublishedKey() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.badIDTokens = true
+}
+
+// FailRevocations makes the revocation endpoint answer with status and body
+// instead of revoking tokens
+func (p *Provider) FailRevocations(status int, body string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.revokeStatus = status
+	p.revokeBody = body
+}
+
+// RevokedTokens returns the tokens revoked so far, in order
+func (p *Provider) RevokedTokens() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string(nil), p.revoked...)
+}
+
+// IDClaims returns the claims of an ID token for sub issued to clientID
+func (p *Provider) IDClaims(clientID, sub string) jwt.MapClaims {
+	return jwt.MapClaims{
+		"iss":                p.Issuer(),
+		"sub":                sub,
+		"aud":                clientID,
+		"typ":                "ID",
+		"iat":                time.Now().Unix(),
+		"exp":                time.Now().Add(5 * time.Minute).Unix(),
+		"preferred_username": sub,
+	}
+}
+
+// LogoutClaims returns the claims of a valid back-channel logout token for
+// clientID, issued now with a fresh jti
+func (p *Provider) LogoutClaims(clientID, sub, sid string) jwt.MapClaims {
+	claims := jwt.MapClaims{
+		"iss":    p.Issuer(),
+		"aud":    clientID,
+		"iat":    time.Now().Unix(),
+		"exp":    time.Now().Add(time.Minute).Unix(),
+		"jti":    uuid.New().String(),
+		"events": map[string]interface{}{"http://schemas.openid.net/event/backchannel-logout": map[string]interface{}{}},
+		"typ":    "Logout",
+	}
+	if sub != "" {
+		claims["sub"] = sub
+	}
+	if sid != "" {
+		claims["sid"] = sid
+	}
+	return claims
+}
+
+// AccessClaims returns the claims of a Keycloak access token for sub with
+// the given audience, valid for five minutes
+func (p *Provider) AccessClaims(sub string, aud ...string) jwt.MapClaims {
+	return jwt.MapClaims{
+		"iss": p.Issuer(),
+		"sub": sub,
+		"aud": aud,
+		"azp": "frontend",
+		"typ": "Bearer",
+		"iat": time.Now().Unix(),
+		"exp": time.Now().Add(5 * time.Minute).Unix(),
+	}
+}
+
+func (p *Provider) handleDiscovery(w http.ResponseWriter, r *http.Request) {
+	base := p.Issuer() + "/protocol/openid-connect"
+	writeJSON(w, map[string]interface{}{
+		"issuer":                                p.Issuer(),
+		"authorization_endpoint":                base + "/auth",
+		"token_endpoint":                        base + "/token",
+		"jwks_uri":                              base + "/certs",
+		"end_session_endpoint":                  base + "/logout",
+		"revocation_endpoint":                   base + "/revoke",
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+	})
+}
+
+// handleToken implements the token endpoint
+func (p *Provider) handleToken(t *testing.T, w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request")
+		return
+	}
+	switch r.PostForm.Get("grant_type") {
+	case "authorization_code":
+		p.handleAuthCodeGrant(t, w, r)
+	case "refresh_token":
+		p.handleRefreshGrant(t, w, r)
+	default:
+		writeError(w, http.StatusBadRequest, "unsupported_grant_type")
+	}
+}
+
+// handleAuthCodeGrant redeems a code from Authorize. Codes are single use,
+// and the code_verifier must match the code_challenge of the login.
+func (p *Provider) handleAuthCodeGrant(t *testing.T, w http.ResponseWriter, r *http.Request) {
+	digest := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+
+	p.mu.Lock()
+	login, ok := p.authCodes[r.PostForm.Get("code")]
+	delete(p.authCodes, r.PostForm.Get("code"))
+	if !ok || base64.RawURLEncoding.EncodeToString(digest[:]) != login.codeChallenge {
+		p.mu.Unlock()
+		writeError(w, http.StatusBadRequest, "invalid_grant")
+		return
+	}
+	refreshToken := p.issueRefreshTokenLocked(login.sub)
+	p.mu.Unlock()
+
+	idClaims := p.IDClaims(ClientID, login.sub)
+	idClaims["nonce"] = login.nonce
+	if login.sid != "" {
+		idClaims["sid"] = login.sid
+	}
+	writeJSON(w, map[string]interface{}{
+		"access_token":  p.Sign(t, p.AccessClaims(login.sub, ClientID)),
+		"refresh_token": refreshToken,
+		"id_token":      p.Sign(t, idClaims),
+		"token_type":    "Bearer",
+		"expires_in":    300,
+	})
+}
+
+// handleRefreshGrant implements the refresh token grant. Refresh tokens
+// rotate, and unknown ones are rejected with invalid_grant like an ended
+// Keycloak session.
+func (p *Provider) handleRefreshGrant(t *testing.T, w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	p.refreshGrants++
+	sub, ok := p.refreshTokens[r.PostForm.Get("refresh_token")]
+	if !ok {
+		p.mu.Unlock()
+		writeError(w, http.StatusBadRequest, "invalid_grant")
+		return
+	}
+	delete(p.refreshTokens, r.PostForm.Get("refresh_token"))
+	refreshToken := p.issueRefreshTokenLocked(sub)
+	badIDTokens := p.badIDTokens
+	p.mu.Unlock()
+
+	idToken := p.Sign(t, p.IDClaims(ClientID, sub))
+	if badIDTokens {
+		idToken = p.SignWithUnpublishedKey(t, p.IDClaims(ClientID, sub))
+	}
+	writeJSON(w, map[string]interface{}{
+		"access_token":  p.Sign(t, p.AccessClaims(sub, ClientID)),
+		"refresh_token": refreshToken,
+		"id_token":      idToken,
+		"token_type":    "Bearer",
+		"expires_in":    300,
+	})
+}
+
+// handleRevoke implements token revocation (RFC 7009) for the client
+// credentials of Config. Revoked refresh tokens can no longer be used.
+func (p *Provider) handleRevoke(w http.ResponseWriter, r *http.Request) {
+	clientID, secret, ok := r.BasicAuth()
+	if !ok || clientID != ClientID || secret != "secret" {
+		writeError(w, http.StatusUnauthorized, "invalid_client")
+		return
+	}
+	if err := r.ParseForm(); err != nil || r.PostForm.Get("token") == "" {
+		writeError(w, http.StatusBadRequest, "invalid_request")
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.revokeStatus != 0 {
+		w.WriteHeader(p.revokeStatus)
+		fmt.Fprint(w, p.revokeBody)
+		return
+	}
+	token := r.PostForm.Get("token")
+	p.revoked = append(p.revoked, token)
+	delete(p.refreshTokens, token)
+}
+
+func (p *Provider) handleJWKS(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	p.jwksRequests++
+	keys := make([]map[string]string, 0, len(p.keys))
+	for _, k := range p.keys {
+		keys = append(keys, map[string]string{
+			"kty": "RSA",
+			"use": "sig",
+			"alg": "RS256",
+			"kid": k.kid,
+			"n":   base64.RawURLEncoding.EncodeToString(k.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(k.key.E)).Bytes()),
+		})
+	}
+	p.mu.Unlock()
+	writeJSON(w, map[string]interface{}{"keys": keys})
+}
+
+// newSigningKey generates an RSA key with a random kid
+func newSigningKey(t *testing.T) signingKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate RSA key: %v", err)
+	}
+	return signingKey{kid: uuid.New().String(), key: key}
+}
+
+// sign returns claims as an RS256 JWT with kid in the header
+func sign(t *testing.T, kid string, key *rsa.PrivateKey, claims jwt.MapClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("sign token: %v", err)
+	}
+	return signed
+}
+
+// writeError writes an OAuth2 error response
+func writeError(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": code})
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		panic(fmt.Sprintf("oidctest: encode response: %v", err))
+	}
+}
@@ -0,0 +1,58 @@
+package services
+
+import (
+	"sync"
+	"time"
+)
+
+// ReplayCache remembers logout token IDs (jti) so that a captured token
+// cannot be accepted twice. Implementations must be safe for concurrent use;
+// a shared backend lets several replicas reject each other's replays.
+type ReplayCache interface {
+	// MarkSeen records jti until expiresAt. It returns false if the jti is
+	// already recorded and has not expired yet.
+	MarkSeen(jti string, expiresAt time.Time) (bool, error)
+}
+
+// MemoryReplayCache is an in-process ReplayCache
+type MemoryReplayCache struct {
+	mu        sync.Mutex
+	entries   map[string]time.Time
+	lastPrune time.Time
+	now       func() time.Time
+}
+
+// NewMemoryReplayCache creates an empty in-memory replay cache
+func NewMemoryReplayCache() *MemoryReplayCache {
+	return &MemoryReplayCache{
+		entries: make(map[string]time.Time),
+		now:     time.Now,
+	}
+}
+
+// MarkSeen implements ReplayCache
+func (m *MemoryReplayCache) MarkSeen(jti string, expiresAt time.Time) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	if now.Sub(m.lastPrune) > time.Minute {
+		m.pruneLocked(now)
+	}
+
+	if existing, exists := m.entries[jti]; exists && now.Before(existing) {
+		return false, nil
+	}
+	m.entries[jti] = expiresAt
+	return true, nil
+}
+
+// pruneLocked drops expired entries. Callers must hold mu.
+func (m *MemoryReplayCache) pruneLocked(now time.Time) {
+	for jti, expiresAt := range m.entries {
+		if !now.Before(expiresAt) {
+			delete(m.entries, jti)
+		}
+	}
+	m.lastPrune = now
+}
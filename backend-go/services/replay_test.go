@@ -0,0 +1,64 @@
+package services_test
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"keycloak-logout-backend-go/services"
+)
+
+func TestMemoryReplayCacheMarkSeen(t *testing.T) {
+	cache := services.NewMemoryReplayCache()
+	until := time.Now().Add(time.Minute)
+
+	if fresh, err := cache.MarkSeen("jti-1", until); !fresh || err != nil {
+		t.Fatalf("first MarkSeen = %v, %v; want true, nil", fresh, err)
+	}
+	if fresh, _ := cache.MarkSeen("jti-1", until); fresh {
+		t.Error("second MarkSeen of the same jti reported it fresh")
+	}
+	if fresh, _ := cache.MarkSeen("jti-2", until); !fresh {
+		t.Error("MarkSeen of another jti reported a replay")
+	}
+}
+
+func TestMemoryReplayCacheExpiredEntry(t *testing.T) {
+	cache := services.NewMemoryReplayCache()
+
+	cache.MarkSeen("jti-1", time.Now().Add(-time.Second))
+	if fresh, _ := cache.MarkSeen("jti-1", time.Now().Add(time.Minute)); !fresh {
+		t.Error("MarkSeen rejected a jti whose entry had expired")
+	}
+}
+
+func TestMemoryReplayCacheConcurrentReplays(t *testing.T) {
+	cache := services.NewMemoryReplayCache()
+	until := time.Now().Add(time.Minute)
+
+	const attempts = 64
+	var accepted atomic.Int32
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			fresh, err := cache.MarkSeen("jti-1", until)
+			if err != nil {
+				t.Errorf("MarkSeen: %v", err)
+			}
+			if fresh {
+				accepted.Add(1)
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	if got := accepted.Load(); got != 1 {
+		t.Errorf("%d of %d concurrent MarkSeen calls accepted the jti; want exactly 1", got, attempts)
+	}
+}
@@ -1,109 +1,486 @@
 package services
 
 import (
-	"log"
+	"context"
+	"fmt"
+	"log/slog"
 	"sync"
 	"time"
 
+	"github.com/google/uuid"
+
+	"keycloak-logout-backend-go/metrics"
 	"keycloak-logout-backend-go/models"
 )
 
-// SessionService manages user sessions and SSE connections
+// touchInterval is the minimum time between LastSeen updates of a session
+const touchInterval = 30 * time.Second
+
+// SessionService manages user sessions and the connections subscribed to
+// their events
 type SessionService struct {
-	activeSessions  map[string]*models.SessionData
-	sessionsMutex   sync.RWMutex
-	sseClients      map[string]*models.SSEClient
-	sseClientsMutex sync.RWMutex
+	store              SessionStore
+	eventBus           EventBus
+	dispatcher         *Dispatcher
+	notifyOptions      NotifyOptions
+	subscribers        map[string]Subscriber          // subscriber ID -> subscriber
+	sessionSubscribers map[string]map[string]struct{} // session ID -> subscriber IDs
+	subscribersMutex   sync.RWMutex
+	shutdownEvent      *models.SessionEvent // set once the server is shutting down
+	eventLog           *EventLog
+	replicaID          string // identifies this replica's logout targets on the bus
+}
+
+// NotifyOptions configures how session events reach subscribers
+type NotifyOptions struct {
+	QueueSize         int    // events queued per subscriber
+	DropPolicy        string // applied when a subscriber's queue is full
+	DispatchQueueSize int    // events waiting to be published on the bus
 }
 
-// NewSessionService creates a new session service
-func NewSessionService() *SessionService {
-	return &SessionService{
-		activeSessions: make(map[string]*models.SessionData),
-		sseClients:     make(map[string]*models.SSEClient),
+// NewSessionService creates a new session service on top of a session store.
+// Notifications are published on the event bus by a background dispatcher,
+// and events received from the bus are queued for this replica's
+// subscribers.
+func NewSessionService(store SessionStore, eventBus EventBus, opts NotifyOptions) *SessionService {
+	s := &SessionService{
+		store:              store,
+		eventBus:           eventBus,
+		notifyOptions:      opts,
+		subscribers:        make(map[string]Subscriber),
+		sessionSubscribers: make(map[string]map[string]struct{}),
+		eventLog:           NewEventLog(eventLogSize, eventLogRetention),
+		replicaID:          uuid.New().String(),
 	}
+	s.dispatcher = NewDispatcher(eventBus, opts.DispatchQueueSize, s.deliverLocal)
+	eventBus.Subscribe(s.deliverLocal)
+	return s
 }
 
-// AddSession adds a new user session
-func (s *SessionService) AddSession(userID string, sessionData *models.SessionData) {
-	s.sessionsMutex.Lock()
-	defer s.sessionsMutex.Unlock()
-	s.activeSessions[userID] = sessionData
+// Close stops the dispatcher after publishing the queued events, waiting at
+// most until ctx ends
+func (s *SessionService) Close(ctx context.Context) error {
+	return s.dispatcher.Close(ctx)
 }
 
-// GetSession retrieves a user session
-func (s *SessionService) GetSession(userID string) (*models.SessionData, bool) {
-	s.sessionsMutex.RLock()
-	defer s.sessionsMutex.RUnlock()
-	session, exists := s.activeSessions[userID]
+// AddSession adds a new session. A user may hold several sessions at once,
+// one per login.
+func (s *SessionService) AddSession(sessionData *models.SessionData) error {
+	return s.store.Add(sessionData)
+}
+
+// GetSession retrieves a session by its ID
+func (s *SessionService) GetSession(ctx context.Context, sessionID string) (*models.SessionData, bool) {
+	session, exists, err := s.store.Get(sessionID)
+	if err != nil {
+		slog.ErrorContext(ctx, "session store: get failed", "session_id", sessionID, "error", err)
+		return nil, false
+	}
 	return session, exists
 }
 
-// RemoveSession removes a user session
-func (s *SessionService) RemoveSession(userID string) {
-	s.sessionsMutex.Lock()
-	defer s.sessionsMutex.Unlock()
-	delete(s.activeSessions, userID)
+// GetSessionsBySID returns the sessions bound to an IdP session ID
+func (s *SessionService) GetSessionsBySID(sid string) []*models.SessionData {
+	sessions, err := s.store.ListBySID(sid)
+	if err != nil {
+		slog.Error("session store: list by sid failed", "sid", sid, "error", err)
+		return nil
+	}
+	return sessions
+}
+
+// GetSessionsForUser returns all active sessions of a user
+func (s *SessionService) GetSessionsForUser(userID string) []*models.SessionData {
+	sessions, err := s.store.ListForUser(userID)
+	if err != nil {
+		slog.Error("session store: list for user failed", "target_user_id", userID, "error", err)
+		return nil
+	}
+	return sessions
+}
+
+// RemoveSessionByID removes a single session and returns it
+func (s *SessionService) RemoveSessionByID(ctx context.Context, sessionID string) (*models.SessionData, bool) {
+	session, exists, err := s.store.Remove(sessionID)
+	if err != nil {
+		slog.ErrorContext(ctx, "session store: remove failed", "session_id", sessionID, "error", err)
+		return nil, false
+	}
+	return session, exists
+}
+
+// RemoveAllForUser removes every session of a user and returns them
+func (s *SessionService) RemoveAllForUser(userID string) []*models.SessionData {
+	var removed []*models.SessionData
+	for _, session := range s.GetSessionsForUser(userID) {
+		if session, exists := s.RemoveSessionByID(context.Background(), session.SessionID); exists {
+			removed = append(removed, session)
+		}
+	}
+	return removed
+}
+
+// RemoveSessionsForLogout removes the sessions targeted by a logout token and
+// returns them. A sid alone ends every session bound to that IdP session, a
+// sub alone ends all of the user's sessions, and both together must match.
+func (s *SessionService) RemoveSessionsForLogout(sub, sid string) []*models.SessionData {
+	if sid == "" {
+		if sub == "" {
+			return nil
+		}
+		return s.RemoveAllForUser(sub)
+	}
+
+	var removed []*models.SessionData
+	for _, session := range s.GetSessionsBySID(sid) {
+		if sub != "" && session.User.ID != sub {
+			continue
+		}
+		if session, exists := s.RemoveSessionByID(context.Background(), session.SessionID); exists {
+			removed = append(removed, session)
+		}
+	}
+	return removed
+}
+
+// UpdateTokens replaces the stored tokens of a session and, if profile is
+// not nil, the user profile derived from them
+func (s *SessionService) UpdateTokens(sessionID string, tokens *models.TokenSet, profile *models.UserProfile) error {
+	updated, err := s.store.Update(sessionID, func(session *models.SessionData) {
+		session.Tokens = tokens
+		if profile != nil {
+			session.User = *profile
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("failed to store tokens: %w", err)
+	}
+	if !updated {
+		return ErrSessionNotFound
+	}
+	return nil
+}
+
+// TouchSession records activity on a session. Writes are throttled so that
+// persistent stores are not rewritten on every request.
+func (s *SessionService) TouchSession(ctx context.Context, sessionID string) {
+	now := time.Now()
+	session, exists := s.GetSession(ctx, sessionID)
+	if !exists || now.Sub(session.LastSeen) < touchInterval {
+		return
+	}
+	if _, err := s.store.Update(sessionID, func(session *models.SessionData) {
+		session.LastSeen = now
+	}); err != nil {
+		slog.ErrorContext(ctx, "session store: touch failed", "session_id", sessionID, "error", err)
+	}
+}
+
+// StartSweeper evicts sessions past their absolute expiry or idle for longer
+// than idleTimeout (0 disables the idle check), every interval until ctx is
+// cancelled. Clients of evicted sessions receive a session_expired event.
+func (s *SessionService) StartSweeper(ctx context.Context, interval, idleTimeout time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				s.sweep(ctx, now, idleTimeout)
+			}
+		}
+	}()
+}
+
+// sweep removes expired sessions once
+func (s *SessionService) sweep(ctx context.Context, now time.Time, idleTimeout time.Duration) {
+	for _, session := range s.GetAllSessions(ctx) {
+		reason := expiryReason(session, now, idleTimeout)
+		if reason == "" {
+			continue
+		}
+		if removed, exists := s.RemoveSessionByID(ctx, session.SessionID); exists {
+			slog.InfoContext(ctx, "session expired", "session_id", removed.SessionID, "target_user_id", removed.User.ID, "reason", reason)
+			s.notify(removed, EventSessionExpired, reason)
+		}
+	}
+}
+
+// expiryReason reports why a session is past its absolute or idle limit, or
+// "" if it is still valid
+func expiryReason(session *models.SessionData, now time.Time, idleTimeout time.Duration) string {
+	if !session.ExpiresAt.IsZero() && now.After(session.ExpiresAt) {
+		return ReasonMaxLifetime
+	}
+	lastSeen := session.LastSeen
+	if lastSeen.IsZero() {
+		lastSeen = session.LoginTime
+	}
+	if idleTimeout > 0 && now.Sub(lastSeen) > idleTimeout {
+		return ReasonIdleTimeout
+	}
+	return ""
 }
 
 // GetAllSessions returns all active sessions
-func (s *SessionService) GetAllSessions() []*models.SessionData {
-	s.sessionsMutex.RLock()
-	defer s.sessionsMutex.RUnlock()
-	
-	sessions := make([]*models.SessionData, 0, len(s.activeSessions))
-	for _, session := range s.activeSessions {
-		sessions = append(sessions, session)
+func (s *SessionService) GetAllSessions(ctx context.Context) []*models.SessionData {
+	sessions, err := s.store.List()
+	if err != nil {
+		slog.ErrorContext(ctx, "session store: list failed", "error", err)
+		return nil
 	}
 	return sessions
 }
 
-// AddSSEClient adds a new SSE client
-func (s *SessionService) AddSSEClient(userID string, client *models.SSEClient) {
-	s.sseClientsMutex.Lock()
-	defer s.sseClientsMutex.Unlock()
-	s.sseClients[userID] = client
-	log.Printf("➕ SSE client added for user: %s (total: %d)", userID, len(s.sseClients))
-}
-
-// RemoveSSEClient removes an SSE client
-func (s *SessionService) RemoveSSEClient(userID string) {
-	s.sseClientsMutex.Lock()
-	defer s.sseClientsMutex.Unlock()
-	if client, exists := s.sseClients[userID]; exists {
-		close(client.Done)
-		delete(s.sseClients, userID)
-		log.Printf("➖ SSE client removed for user: %s (remaining: %d)", userID, len(s.sseClients))
-	}
-}
-
-// NotifySessionInvalidated notifies SSE clients about session invalidation
-func (s *SessionService) NotifySessionInvalidated(userID string) {
-	s.sseClientsMutex.RLock()
-	client, exists := s.sseClients[userID]
-	s.sseClientsMutex.RUnlock()
-	
-	log.Printf("🔔 NotifySessionInvalidated called for user: %s", userID)
-	log.Printf("📋 SSE client exists: %v", exists)
-	
-	if exists {
-		log.Printf("📤 Sending session_invalidated message to SSE client: %s", userID)
-		select {
-		case client.C <- "session_invalidated":
-			log.Printf("✅ Message sent successfully to SSE client: %s", userID)
-		case <-time.After(1 * time.Second):
-			log.Printf("⏰ SSE client not responding, removing: %s", userID)
-			// Client not responding, remove it
-			s.RemoveSSEClient(userID)
+// EventsSince returns the logged events of a session after lastEventID, for
+// subscribers resuming with Last-Event-ID
+func (s *SessionService) EventsSince(sessionID, lastEventID string) []models.SessionEvent {
+	return s.eventLog.Since(sessionID, lastEventID)
+}
+
+// ActiveSessionCount returns the number of sessions in the store
+func (s *SessionService) ActiveSessionCount() int {
+	return len(s.GetAllSessions(context.Background()))
+}
+
+// Subscribe creates and registers a subscriber for a session, with the
+// configured queue size and drop policy. Callers remove it with
+// RemoveSubscriber when the connection ends.
+func (s *SessionService) Subscribe(transport, userID, sessionID string) *QueueSubscriber {
+	sub := NewQueueSubscriber(transport, userID, sessionID, s.notifyOptions.QueueSize, s.notifyOptions.DropPolicy)
+	s.AddSubscriber(sub)
+	return sub
+}
+
+// SubscriberStats returns the queue statistics of every subscriber
+func (s *SessionService) SubscriberStats() []SubscriberStats {
+	s.subscribersMutex.RLock()
+	defer s.subscribersMutex.RUnlock()
+	stats := make([]SubscriberStats, 0, len(s.subscribers))
+	for _, sub := range s.subscribers {
+		stats = append(stats, sub.Stats())
+	}
+	return stats
+}
+
+// QueuedEvents returns the number of events queued for all subscribers
+func (s *SessionService) QueuedEvents() int {
+	total := 0
+	for _, stats := range s.SubscriberStats() {
+		total += stats.Queued
+	}
+	return total
+}
+
+// HasPeers reports whether other replicas may hold sessions, so that a
+// session missing here may still exist elsewhere
+func (s *SessionService) HasPeers() bool {
+	return s.eventBus.HasPeers()
+}
+
+// DispatchQueueDepth returns the number of events waiting to be published
+func (s *SessionService) DispatchQueueDepth() int {
+	return s.dispatcher.Depth()
+}
+
+// DropPolicy returns the policy applied to full subscriber queues
+func (s *SessionService) DropPolicy() string {
+	return s.notifyOptions.DropPolicy
+}
+
+// AddSubscriber registers a connection that receives the events of its
+// session. Every browser tab gets its own subscriber, so a user may have
+// several per session.
+func (s *SessionService) AddSubscriber(sub Subscriber) {
+	s.subscribersMutex.Lock()
+	defer s.subscribersMutex.Unlock()
+	s.subscribers[sub.ID()] = sub
+	addToIndex(s.sessionSubscribers, sub.SessionID(), sub.ID())
+	metrics.Subscribers.WithLabelValues(sub.Transport()).Inc()
+	slog.Debug("subscriber added", "subscriber_id", sub.ID(), "transport", sub.Transport(), "session_id", sub.SessionID(), "total", len(s.subscribers))
+
+	// A connection that slipped in during shutdown is told to go at once
+	if s.shutdownEvent != nil {
+		sub.Send(*s.shutdownEvent)
+	}
+}
+
+// NotifyShutdown sends every local subscriber, and any added later, a
+// server_shutdown event asking it to reconnect after retry. Connection
+// handlers end their streams on this event. It returns the number of
+// subscribers notified.
+func (s *SessionService) NotifyShutdown(retry time.Duration) int {
+	event := models.SessionEvent{
+		Type:      EventServerShutdown,
+		RetryMs:   retry.Milliseconds(),
+		Timestamp: time.Now(),
+	}
+
+	s.subscribersMutex.Lock()
+	defer s.subscribersMutex.Unlock()
+	s.shutdownEvent = &event
+	notified := len(s.subscribers)
+	for subscriberID, sub := range s.subscribers {
+		if !sub.Send(event) {
+			s.removeSubscriberLocked(subscriberID)
+		}
+	}
+	return notified
+}
+
+// RemoveSubscriber removes a subscriber and signals it to stop
+func (s *SessionService) RemoveSubscriber(subscriberID string) {
+	s.subscribersMutex.Lock()
+	defer s.subscribersMutex.Unlock()
+	s.removeSubscriberLocked(subscriberID)
+}
+
+// RemoveSubscribersForSession disconnects every subscriber of a session
+func (s *SessionService) RemoveSubscribersForSession(sessionID string) {
+	s.subscribersMutex.Lock()
+	defer s.subscribersMutex.Unlock()
+	for subscriberID := range s.sessionSubscribers[sessionID] {
+		s.removeSubscriberLocked(subscriberID)
+	}
+}
+
+// removeSubscriberLocked removes a subscriber and its index entries.
+// Callers must hold subscribersMutex.
+func (s *SessionService) removeSubscriberLocked(subscriberID string) {
+	sub, exists := s.subscribers[subscriberID]
+	if !exists {
+		return
+	}
+	sub.Close()
+	delete(s.subscribers, subscriberID)
+	removeFromIndex(s.sessionSubscribers, sub.SessionID(), subscriberID)
+	metrics.Subscribers.WithLabelValues(sub.Transport()).Dec()
+	slog.Debug("subscriber removed", "subscriber_id", subscriberID, "transport", sub.Transport(), "session_id", sub.SessionID(), "remaining", len(s.subscribers))
+}
+
+// EndSessions ends the sessions selected by target on every replica. Matching
+// sessions in this replica's store are removed at once, their subscribers are
+// notified, and they are returned; the target is also published so that
+// other replicas end the matching sessions in their own stores.
+func (s *SessionService) EndSessions(target LogoutTarget) []*models.SessionData {
+	removed := s.endLocalSessions(target)
+
+	target.Origin = s.replicaID
+	if !s.dispatcher.Enqueue(BusEvent{Logout: &target}) {
+		slog.Error("dispatch queue full or closed, logout not sent to other replicas", "sub", target.Subject, "sid", target.SID, "session_id", target.SessionID)
+	}
+	return removed
+}
+
+// endLocalSessions removes the sessions in this replica's store matching
+// target and notifies their subscribers
+func (s *SessionService) endLocalSessions(target LogoutTarget) []*models.SessionData {
+	var removed []*models.SessionData
+	if target.SessionID != "" {
+		if target.SID != "" {
+			if session, exists := s.GetSession(context.Background(), target.SessionID); !exists || session.IdPSessionID != target.SID {
+				return nil
+			}
+		}
+		if session, exists := s.RemoveSessionByID(context.Background(), target.SessionID); exists {
+			removed = append(removed, session)
 		}
 	} else {
-		log.Printf("❌ No SSE client found for user: %s", userID)
-		// Show all current SSE clients for debugging
-		s.sseClientsMutex.RLock()
-		log.Printf("📊 Current SSE clients: %d", len(s.sseClients))
-		for id := range s.sseClients {
-			log.Printf("  - Client ID: %s", id)
+		removed = s.RemoveSessionsForLogout(target.Subject, target.SID)
+	}
+	for _, session := range removed {
+		s.NotifySessionInvalidated(session, target.Reason)
+	}
+	return removed
+}
+
+// NotifySessionInvalidated tells every subscriber of a session, on any
+// replica, that the session has ended. If the event bus is unavailable the
+// local subscribers are still notified.
+func (s *SessionService) NotifySessionInvalidated(session *models.SessionData, reason string) {
+	s.notify(session, EventSessionInvalidated, reason)
+}
+
+// notify publishes a session event on the bus, falling back to local delivery
+func (s *SessionService) notify(session *models.SessionData, eventType, reason string) {
+	now := time.Now()
+	event := BusEvent{
+		UserID: session.User.ID,
+		SessionEvent: models.SessionEvent{
+			ID:        newEventID(now),
+			Type:      eventType,
+			SessionID: session.SessionID,
+			Reason:    reason,
+			Timestamp: now,
+		},
+	}
+
+	if !s.dispatcher.Enqueue(event) {
+		slog.Error("dispatch queue full or closed, session event dropped", "type", eventType, "session_id", session.SessionID)
+	}
+}
+
+// deliverLocal handles a message from the bus. Logout targets end matching
+// local sessions; session events are recorded in the event log and queued
+// for this replica's subscribers of the session. Delivery never blocks: a full
+// subscriber queue is handled by the drop policy, and a subscriber that is
+// disconnected or misses events can catch up from the log when it
+// reconnects.
+func (s *SessionService) deliverLocal(event BusEvent) {
+	if event.Logout != nil {
+		s.applyLogout(*event.Logout)
+		return
+	}
+
+	s.eventLog.Append(event.UserID, event.SessionEvent)
+
+	s.subscribersMutex.RLock()
+	subs := make([]Subscriber, 0, len(s.sessionSubscribers[event.SessionID]))
+	for subscriberID := range s.sessionSubscribers[event.SessionID] {
+		subs = append(subs, s.subscribers[subscriberID])
+	}
+	s.subscribersMutex.RUnlock()
+
+	slog.Debug("delivering session event", "type", event.Type, "session_id", event.SessionID, "subscribers", len(subs))
+
+	for _, sub := range subs {
+		if sub.Send(event.SessionEvent) {
+			metrics.Notifications.WithLabelValues(metrics.NotificationDelivered).Inc()
+		} else {
+			slog.Warn("subscriber queue full, disconnecting", "subscriber_id", sub.ID(), "transport", sub.Transport(), "session_id", event.SessionID)
+			s.RemoveSubscriber(sub.ID())
 		}
-		s.sseClientsMutex.RUnlock()
 	}
-}
\ No newline at end of file
+}
+
+// applyLogout ends the local sessions matching a logout target received
+// from the bus. The publishing replica has already applied it.
+func (s *SessionService) applyLogout(target LogoutTarget) {
+	if target.Origin == s.replicaID {
+		return
+	}
+	removed := s.endLocalSessions(target)
+	if len(removed) > 0 {
+		slog.Info("ended sessions for logout from another replica", "sessions", len(removed), "reason", target.Reason, "sid", target.SID, "session_id", target.SessionID)
+	}
+}
+
+// addToIndex adds id to the set stored under key
+func addToIndex(index map[string]map[string]struct{}, key, id string) {
+	if index[key] == nil {
+		index[key] = make(map[string]struct{})
+	}
+	index[key][id] = struct{}{}
+}
+
+// removeFromIndex removes id from the set stored under key, dropping empty sets
+func removeFromIndex(index map[string]map[string]struct{}, key, id string) {
+	if ids := index[key]; ids != nil {
+		delete(ids, id)
+		if len(ids) == 0 {
+			delete(index, key)
+		}
+	}
+}
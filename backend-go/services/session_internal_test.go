@@ -0,0 +1,33 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"keycloak-logout-backend-go/models"
+)
+
+func TestExpiryReason(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name        string
+		session     models.SessionData
+		idleTimeout time.Duration
+		want        string
+	}{
+		{"active", models.SessionData{LastSeen: now.Add(-time.Minute), ExpiresAt: now.Add(time.Hour)}, 30 * time.Minute, ""},
+		{"past absolute expiry", models.SessionData{LastSeen: now, ExpiresAt: now.Add(-time.Second)}, 30 * time.Minute, ReasonMaxLifetime},
+		{"absolute expiry wins over idle", models.SessionData{LastSeen: now.Add(-time.Hour), ExpiresAt: now.Add(-time.Second)}, 30 * time.Minute, ReasonMaxLifetime},
+		{"idle", models.SessionData{LastSeen: now.Add(-time.Hour), ExpiresAt: now.Add(time.Hour)}, 30 * time.Minute, ReasonIdleTimeout},
+		{"never seen falls back to login time", models.SessionData{LoginTime: now.Add(-time.Hour)}, 30 * time.Minute, ReasonIdleTimeout},
+		{"idle check disabled", models.SessionData{LastSeen: now.Add(-time.Hour)}, 0, ""},
+		{"no absolute expiry", models.SessionData{LastSeen: now}, 30 * time.Minute, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := expiryReason(&tt.session, now, tt.idleTimeout); got != tt.want {
+				t.Errorf("expiryReason = %q; want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,206 @@
+package services_test
+
+import (
+	"context"
+	"sort"
+	"testing"
+	"time"
+
+	"keycloak-logout-backend-go/models"
+	"keycloak-logout-backend-go/services"
+	"keycloak-logout-backend-go/services/storetest"
+)
+
+// newTestSessionService creates a session service with a memory store and a
+// local event bus
+func newTestSessionService(t *testing.T) *services.SessionService {
+	t.Helper()
+	return newTestSessionServiceOn(t, services.NewMemorySessionStore(), services.NewLocalEventBus())
+}
+
+// newTestSessionServiceOn creates a session service on the given store and
+// bus, closed when the test ends
+func newTestSessionServiceOn(t *testing.T, store services.SessionStore, bus services.EventBus) *services.SessionService {
+	t.Helper()
+	s := services.NewSessionService(store, bus, services.NotifyOptions{
+		QueueSize:         16,
+		DropPolicy:        services.DropOldest,
+		DispatchQueueSize: 64,
+	})
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		s.Close(ctx)
+	})
+	return s
+}
+
+// addTestSession adds a session of userID bound to sid
+func addTestSession(t *testing.T, s *services.SessionService, sessionID, userID, sid string) {
+	t.Helper()
+	if err := s.AddSession(storetest.NewSession(sessionID, userID, sid)); err != nil {
+		t.Fatalf("AddSession(%s): %v", sessionID, err)
+	}
+}
+
+// removedIDs returns the sorted IDs of removed sessions
+func removedIDs(sessions []*models.SessionData) []string {
+	ids := make([]string, 0, len(sessions))
+	for _, session := range sessions {
+		ids = append(ids, session.SessionID)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func TestRemoveSessionsForLogout(t *testing.T) {
+	tests := []struct {
+		name      string
+		sub, sid  string
+		want      []string
+		remaining []string
+	}{
+		{"sid ends every login of the IdP session", "", "sid-1", []string{"s1", "s2"}, []string{"s3", "s4"}},
+		{"sub and sid must match", "user-1", "sid-1", []string{"s1", "s2"}, []string{"s3", "s4"}},
+		{"sid of another user", "user-2", "sid-1", nil, []string{"s1", "s2", "s3", "s4"}},
+		{"sub ends all sessions of the user", "user-1", "", []string{"s1", "s2", "s3"}, []string{"s4"}},
+		{"neither", "", "", nil, []string{"s1", "s2", "s3", "s4"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestSessionService(t)
+			// s1 and s2 are two logins in one browser sharing the SSO session
+			addTestSession(t, s, "s1", "user-1", "sid-1")
+			addTestSession(t, s, "s2", "user-1", "sid-1")
+			addTestSession(t, s, "s3", "user-1", "sid-2")
+			addTestSession(t, s, "s4", "user-2", "sid-3")
+
+			got := removedIDs(s.RemoveSessionsForLogout(tt.sub, tt.sid))
+			if !equalStrings(got, tt.want) {
+				t.Errorf("removed %v; want %v", got, tt.want)
+			}
+			if remaining := removedIDs(s.GetAllSessions(context.Background())); !equalStrings(remaining, tt.remaining) {
+				t.Errorf("remaining %v; want %v", remaining, tt.remaining)
+			}
+		})
+	}
+}
+
+// equalStrings compares two string slices, treating nil and empty as equal
+func equalStrings(got, want []string) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestEndSessionsAcrossReplicas(t *testing.T) {
+	// Two replicas with their own stores on one bus
+	bus := services.NewLocalEventBus()
+	replicaA := newTestSessionServiceOn(t, services.NewMemorySessionStore(), bus)
+	replicaB := newTestSessionServiceOn(t, services.NewMemorySessionStore(), bus)
+	addTestSession(t, replicaA, "a1", "user-1", "sid-1")
+	addTestSession(t, replicaB, "b1", "user-1", "sid-2")
+	addTestSession(t, replicaB, "b2", "user-2", "sid-3")
+
+	tests := []struct {
+		name   string
+		target services.LogoutTarget
+		gone   string
+	}{
+		{"by sid", services.LogoutTarget{SID: "sid-2", Reason: services.ReasonFrontchannelLogout}, "b1"},
+		{"by session ID", services.LogoutTarget{SessionID: "b2", Reason: services.ReasonAdminLogout}, "b2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if removed := replicaA.EndSessions(tt.target); len(removed) != 0 {
+				t.Fatalf("replica A removed %v; want none", removedIDs(removed))
+			}
+			waitFor(t, 5*time.Second, "removal on replica B", func() bool {
+				_, exists := replicaB.GetSession(context.Background(), tt.gone)
+				return !exists
+			})
+		})
+	}
+
+	if _, exists := replicaA.GetSession(context.Background(), "a1"); !exists {
+		t.Error("unrelated session on replica A was removed")
+	}
+}
+
+func TestEndSessionsBySubjectOnEveryReplica(t *testing.T) {
+	bus := services.NewLocalEventBus()
+	replicaA := newTestSessionServiceOn(t, services.NewMemorySessionStore(), bus)
+	replicaB := newTestSessionServiceOn(t, services.NewMemorySessionStore(), bus)
+	addTestSession(t, replicaA, "a1", "user-1", "sid-1")
+	addTestSession(t, replicaB, "b1", "user-1", "sid-2")
+
+	if removed := replicaA.EndSessions(services.LogoutTarget{Subject: "user-1", Reason: services.ReasonAdminLogout}); !equalStrings(removedIDs(removed), []string{"a1"}) {
+		t.Errorf("replica A removed %v; want [a1]", removedIDs(removed))
+	}
+	waitFor(t, 5*time.Second, "removal on replica B", func() bool {
+		return len(replicaB.GetSessionsForUser("user-1")) == 0
+	})
+}
+
+func TestEndSessionsBySessionIDChecksSID(t *testing.T) {
+	s := newTestSessionService(t)
+	addTestSession(t, s, "s1", "user-1", "sid-1")
+
+	if removed := s.EndSessions(services.LogoutTarget{SessionID: "s1", SID: "sid-2", Reason: services.ReasonFrontchannelLogout}); len(removed) != 0 {
+		t.Errorf("removed %v for a sid the session is not bound to; want none", removedIDs(removed))
+	}
+	if removed := s.EndSessions(services.LogoutTarget{SessionID: "s1", SID: "sid-1", Reason: services.ReasonFrontchannelLogout}); !equalStrings(removedIDs(removed), []string{"s1"}) {
+		t.Errorf("removed %v; want [s1]", removedIDs(removed))
+	}
+}
+
+func TestSweeperEvictsExpiredSessions(t *testing.T) {
+	s := newTestSessionService(t)
+	now := time.Now()
+	for _, tt := range []struct {
+		sessionID, userID   string
+		lastSeen, expiresAt time.Time
+	}{
+		{"active", "user-1", now, now.Add(time.Hour)},
+		{"idle", "user-1", now.Add(-time.Hour), now.Add(time.Hour)},
+		{"expired", "user-2", now, now.Add(-time.Second)},
+	} {
+		session := storetest.NewSession(tt.sessionID, tt.userID, "")
+		session.LastSeen = tt.lastSeen
+		session.ExpiresAt = tt.expiresAt
+		if err := s.AddSession(session); err != nil {
+			t.Fatalf("AddSession(%s): %v", tt.sessionID, err)
+		}
+	}
+	subs := map[string]*services.QueueSubscriber{
+		"idle":    s.Subscribe(services.TransportSSE, "user-1", "idle"),
+		"expired": s.Subscribe(services.TransportSSE, "user-2", "expired"),
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s.StartSweeper(ctx, 10*time.Millisecond, 30*time.Minute)
+
+	wantReasons := map[string]string{"idle": services.ReasonIdleTimeout, "expired": services.ReasonMaxLifetime}
+	for id, sub := range subs {
+		select {
+		case <-sub.Ready():
+			events := sub.Drain()
+			if len(events) != 1 || events[0].Type != services.EventSessionExpired || events[0].Reason != wantReasons[id] {
+				t.Errorf("session %s: got %+v; want one session_expired with %s", id, events, wantReasons[id])
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("session %s: subscriber was not notified", id)
+		}
+	}
+	if remaining := removedIDs(s.GetAllSessions(context.Background())); !equalStrings(remaining, []string{"active"}) {
+		t.Errorf("remaining %v; want [active]", remaining)
+	}
+}
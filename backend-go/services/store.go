@@ -0,0 +1,169 @@
+package services
+
+import (
+	"sync"
+
+	"keycloak-logout-backend-go/models"
+)
+
+// SessionStore persists active sessions. Implementations must be safe for
+// concurrent use and return copies, so callers may not mutate stored data.
+type SessionStore interface {
+	// Add stores a session, replacing any session with the same ID
+	Add(session *models.SessionData) error
+	// Get returns the session with the given ID
+	Get(sessionID string) (*models.SessionData, bool, error)
+	// ListBySID returns the sessions bound to an IdP session ID (sid claim).
+	// Several logins in one browser share the same Keycloak SSO session.
+	ListBySID(sid string) ([]*models.SessionData, error)
+	// Update atomically applies fn to a stored session. It reports false and
+	// does nothing if the session does not exist.
+	Update(sessionID string, fn func(*models.SessionData)) (bool, error)
+	// Remove deletes a session and returns it
+	Remove(sessionID string) (*models.SessionData, bool, error)
+	// List returns all sessions
+	List() ([]*models.SessionData, error)
+	// ListForUser returns all sessions of a user
+	ListForUser(userID string) ([]*models.SessionData, error)
+	// Close releases resources held by the store
+	Close() error
+}
+
+// MemorySessionStore keeps sessions in process memory
+type MemorySessionStore struct {
+	mu           sync.RWMutex
+	sessions     map[string]*models.SessionData // session ID -> session
+	userSessions map[string]map[string]struct{} // user ID -> session IDs
+	sidIndex     map[string]map[string]struct{} // IdP session ID -> session IDs
+}
+
+// NewMemorySessionStore creates an empty in-memory session store
+func NewMemorySessionStore() *MemorySessionStore {
+	return &MemorySessionStore{
+		sessions:     make(map[string]*models.SessionData),
+		userSessions: make(map[string]map[string]struct{}),
+		sidIndex:     make(map[string]map[string]struct{}),
+	}
+}
+
+// Add implements SessionStore
+func (m *MemorySessionStore) Add(session *models.SessionData) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.removeLocked(session.SessionID)
+	stored := *session
+	m.addLocked(&stored)
+	return nil
+}
+
+// Get implements SessionStore
+func (m *MemorySessionStore) Get(sessionID string) (*models.SessionData, bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	session, exists := m.sessions[sessionID]
+	return copySession(session), exists, nil
+}
+
+// ListBySID implements SessionStore
+func (m *MemorySessionStore) ListBySID(sid string) ([]*models.SessionData, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	sessions := make([]*models.SessionData, 0, len(m.sidIndex[sid]))
+	for sessionID := range m.sidIndex[sid] {
+		sessions = append(sessions, copySession(m.sessions[sessionID]))
+	}
+	return sessions, nil
+}
+
+// Update implements SessionStore
+func (m *MemorySessionStore) Update(sessionID string, fn func(*models.SessionData)) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	session, exists := m.sessions[sessionID]
+	if !exists {
+		return false, nil
+	}
+	updated := *session
+	fn(&updated)
+	updated.SessionID = sessionID
+	m.removeLocked(sessionID)
+	m.addLocked(&updated)
+	return true, nil
+}
+
+// Remove implements SessionStore
+func (m *MemorySessionStore) Remove(sessionID string) (*models.SessionData, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	session, exists := m.removeLocked(sessionID)
+	return session, exists, nil
+}
+
+// List implements SessionStore
+func (m *MemorySessionStore) List() ([]*models.SessionData, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	sessions := make([]*models.SessionData, 0, len(m.sessions))
+	for _, session := range m.sessions {
+		sessions = append(sessions, copySession(session))
+	}
+	return sessions, nil
+}
+
+// ListForUser implements SessionStore
+func (m *MemorySessionStore) ListForUser(userID string) ([]*models.SessionData, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	sessions := make([]*models.SessionData, 0, len(m.userSessions[userID]))
+	for sessionID := range m.userSessions[userID] {
+		sessions = append(sessions, copySession(m.sessions[sessionID]))
+	}
+	return sessions, nil
+}
+
+// Close implements SessionStore
+func (m *MemorySessionStore) Close() error {
+	return nil
+}
+
+// count returns the number of stored sessions
+func (m *MemorySessionStore) count() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return len(m.sessions)
+}
+
+// addLocked stores a session and indexes it. Callers must hold mu.
+func (m *MemorySessionStore) addLocked(session *models.SessionData) {
+	m.sessions[session.SessionID] = session
+	addToIndex(m.userSessions, session.User.ID, session.SessionID)
+	if session.IdPSessionID != "" {
+		addToIndex(m.sidIndex, session.IdPSessionID, session.SessionID)
+	}
+}
+
+// removeLocked removes a session and its index entries. Callers must hold mu.
+func (m *MemorySessionStore) removeLocked(sessionID string) (*models.SessionData, bool) {
+	session, exists := m.sessions[sessionID]
+	if !exists {
+		return nil, false
+	}
+
+	delete(m.sessions, sessionID)
+	if session.IdPSessionID != "" {
+		removeFromIndex(m.sidIndex, session.IdPSessionID, sessionID)
+	}
+	removeFromIndex(m.userSessions, session.User.ID, sessionID)
+	return session, true
+}
+
+// copySession returns a shallow copy of a session, or nil
+func copySession(session *models.SessionData) *models.SessionData {
+	if session == nil {
+		return nil
+	}
+	c := *session
+	return &c
+}
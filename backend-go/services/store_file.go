@@ -0,0 +1,255 @@
+package services
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"keycloak-logout-backend-go/models"
+)
+
+// compactThreshold is the minimum number of log records before the file
+// store rewrites its log
+const compactThreshold = 1000
+
+// fileRecord is one line of the append-only session log
+type fileRecord struct {
+	Op      string              `json:"op"` // "put" or "del"
+	Session *models.SessionData `json:"session,omitempty"`
+	ID      string              `json:"id,omitempty"`
+}
+
+// FileSessionStore is a durable SessionStore backed by an append-only JSON
+// lines file. Sessions are served from memory; every change is appended and
+// synced before it becomes visible, and the log is compacted on open, on
+// close and whenever it grows well beyond the live session count.
+type FileSessionStore struct {
+	mu      sync.Mutex // serializes log writes
+	mem     *MemorySessionStore
+	path    string
+	file    *os.File
+	records int
+}
+
+// NewFileSessionStore opens (or creates) the session log at path and loads
+// the sessions it contains
+func NewFileSessionStore(path string) (*FileSessionStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create session store directory: %w", err)
+	}
+
+	f := &FileSessionStore{
+		mem:  NewMemorySessionStore(),
+		path: path,
+	}
+	if err := f.load(); err != nil {
+		return nil, err
+	}
+	if err := f.compact(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// load replays the log into memory. A truncated final line, left behind by a
+// crash mid-write, is ignored.
+func (f *FileSessionStore) load() error {
+	file, err := os.Open(f.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open session store: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		var record fileRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			slog.Warn("session store: skipping unreadable record", "path", f.path, "line", line, "error", err)
+			continue
+		}
+		switch record.Op {
+		case "put":
+			if record.Session != nil {
+				f.mem.Add(record.Session)
+			}
+		case "del":
+			f.mem.Remove(record.ID)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read session store: %w", err)
+	}
+	return nil
+}
+
+// compact rewrites the log with one record per live session and reopens it
+// for appending. Callers must hold mu or have exclusive access.
+func (f *FileSessionStore) compact() error {
+	sessions, _ := f.mem.List()
+
+	tmpPath := f.path + ".tmp"
+	tmp, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to create compacted session store: %w", err)
+	}
+	w := bufio.NewWriter(tmp)
+	enc := json.NewEncoder(w)
+	for _, session := range sessions {
+		if err := enc.Encode(fileRecord{Op: "put", Session: session}); err != nil {
+			tmp.Close()
+			return fmt.Errorf("failed to write compacted session store: %w", err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write compacted session store: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to sync compacted session store: %w", err)
+	}
+	tmp.Close()
+
+	if f.file != nil {
+		f.file.Close()
+		f.file = nil
+	}
+	if err := os.Rename(tmpPath, f.path); err != nil {
+		return fmt.Errorf("failed to replace session store: %w", err)
+	}
+
+	file, err := os.OpenFile(f.path, os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to reopen session store: %w", err)
+	}
+	f.file = file
+	f.records = len(sessions)
+	return nil
+}
+
+// appendLocked writes a record and syncs it to disk. Callers must hold mu.
+func (f *FileSessionStore) appendLocked(record fileRecord) error {
+	if f.file == nil {
+		return fmt.Errorf("session store is closed")
+	}
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to encode session record: %w", err)
+	}
+	if _, err := f.file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to append session record: %w", err)
+	}
+	if err := f.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync session store: %w", err)
+	}
+	f.records++
+	return nil
+}
+
+// maybeCompactLocked compacts the log once it is mostly dead records.
+// Callers must hold mu.
+func (f *FileSessionStore) maybeCompactLocked() {
+	live := f.mem.count()
+	if f.records < compactThreshold || f.records < 2*live {
+		return
+	}
+	if err := f.compact(); err != nil {
+		slog.Error("session store: compaction failed", "error", err)
+	}
+}
+
+// Add implements SessionStore
+func (f *FileSessionStore) Add(session *models.SessionData) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.appendLocked(fileRecord{Op: "put", Session: session}); err != nil {
+		return err
+	}
+	f.mem.Add(session)
+	f.maybeCompactLocked()
+	return nil
+}
+
+// Get implements SessionStore
+func (f *FileSessionStore) Get(sessionID string) (*models.SessionData, bool, error) {
+	return f.mem.Get(sessionID)
+}
+
+// ListBySID implements SessionStore
+func (f *FileSessionStore) ListBySID(sid string) ([]*models.SessionData, error) {
+	return f.mem.ListBySID(sid)
+}
+
+// Update implements SessionStore. The updated session is appended as a new
+// record.
+func (f *FileSessionStore) Update(sessionID string, fn func(*models.SessionData)) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	session, exists, _ := f.mem.Get(sessionID)
+	if !exists {
+		return false, nil
+	}
+	fn(session)
+	session.SessionID = sessionID
+	if err := f.appendLocked(fileRecord{Op: "put", Session: session}); err != nil {
+		return false, err
+	}
+	f.mem.Add(session)
+	f.maybeCompactLocked()
+	return true, nil
+}
+
+// Remove implements SessionStore
+func (f *FileSessionStore) Remove(sessionID string) (*models.SessionData, bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, exists, _ := f.mem.Get(sessionID); !exists {
+		return nil, false, nil
+	}
+	if err := f.appendLocked(fileRecord{Op: "del", ID: sessionID}); err != nil {
+		return nil, false, err
+	}
+	session, exists, _ := f.mem.Remove(sessionID)
+	f.maybeCompactLocked()
+	return session, exists, nil
+}
+
+// List implements SessionStore
+func (f *FileSessionStore) List() ([]*models.SessionData, error) {
+	return f.mem.List()
+}
+
+// ListForUser implements SessionStore
+func (f *FileSessionStore) ListForUser(userID string) ([]*models.SessionData, error) {
+	return f.mem.ListForUser(userID)
+}
+
+// Close compacts the log and closes the file
+func (f *FileSessionStore) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		return nil
+	}
+	err := f.compact()
+	if f.file != nil {
+		f.file.Close()
+		f.file = nil
+	}
+	return err
+}
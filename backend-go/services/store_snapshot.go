@@ -0,0 +1,81 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"keycloak-logout-backend-go/models"
+)
+
+// SaveSnapshot writes every session in store to path as a JSON array,
+// replacing the file atomically. It returns the number of sessions saved.
+func SaveSnapshot(store SessionStore, path string) (int, error) {
+	sessions, err := store.List()
+	if err != nil {
+		return 0, err
+	}
+	data, err := json.Marshal(sessions)
+	if err != nil {
+		return 0, fmt.Errorf("failed to encode session snapshot: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return 0, fmt.Errorf("failed to create session snapshot directory: %w", err)
+	}
+	tmpPath := path + ".tmp"
+	tmp, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create session snapshot: %w", err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return 0, fmt.Errorf("failed to write session snapshot: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return 0, fmt.Errorf("failed to sync session snapshot: %w", err)
+	}
+	tmp.Close()
+	if err := os.Rename(tmpPath, path); err != nil {
+		return 0, fmt.Errorf("failed to replace session snapshot: %w", err)
+	}
+	return len(sessions), nil
+}
+
+// LoadSnapshot adds the sessions saved at path to store, skipping expired
+// ones, and then deletes the snapshot so that sessions ended after a restart
+// cannot come back from it. A missing snapshot is not an error.
+func LoadSnapshot(store SessionStore, path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to read session snapshot: %w", err)
+	}
+
+	var sessions []*models.SessionData
+	if err := json.Unmarshal(data, &sessions); err != nil {
+		return 0, fmt.Errorf("failed to decode session snapshot: %w", err)
+	}
+	now := time.Now()
+	loaded := 0
+	for _, session := range sessions {
+		if session == nil || (!session.ExpiresAt.IsZero() && now.After(session.ExpiresAt)) {
+			continue
+		}
+		if err := store.Add(session); err != nil {
+			return loaded, err
+		}
+		loaded++
+	}
+
+	if err := os.Remove(path); err != nil {
+		return loaded, fmt.Errorf("failed to remove session snapshot: %w", err)
+	}
+	return loaded, nil
+}
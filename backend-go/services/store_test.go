@@ -0,0 +1,183 @@
+package services_test
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"keycloak-logout-backend-go/models"
+	"keycloak-logout-backend-go/services"
+	"keycloak-logout-backend-go/services/storetest"
+)
+
+func TestMemorySessionStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) services.SessionStore {
+		return services.NewMemorySessionStore()
+	})
+}
+
+func TestFileSessionStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) services.SessionStore {
+		return openFileStore(t, filepath.Join(t.TempDir(), "sessions.jsonl"))
+	})
+}
+
+// openFileStore opens a file session store at path
+func openFileStore(t *testing.T, path string) *services.FileSessionStore {
+	t.Helper()
+	store, err := services.NewFileSessionStore(path)
+	if err != nil {
+		t.Fatalf("NewFileSessionStore: %v", err)
+	}
+	return store
+}
+
+// countLines returns the number of lines in a file
+func countLines(t *testing.T, path string) int {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("open %s: %v", path, err)
+	}
+	defer file.Close()
+	lines := 0
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lines++
+	}
+	return lines
+}
+
+func TestFileSessionStoreReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sessions.jsonl")
+
+	store := openFileStore(t, path)
+	store.Add(storetest.NewSession("s1", "u1", "sid1"))
+	store.Add(storetest.NewSession("s2", "u1", "sid2"))
+	store.Add(storetest.NewSession("s3", "u2", "sid3"))
+	seen := time.Unix(1700000100, 0).UTC()
+	store.Update("s1", func(session *models.SessionData) { session.LastSeen = seen })
+	store.Remove("s2")
+	if err := store.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	reopened := openFileStore(t, path)
+	defer reopened.Close()
+
+	got, exists, _ := reopened.Get("s1")
+	if !exists || !got.LastSeen.Equal(seen) {
+		t.Errorf("Get(s1) after reopen = %+v, %v; want the updated session", got, exists)
+	}
+	if _, exists, _ := reopened.Get("s2"); exists {
+		t.Error("Get(s2) after reopen found a removed session")
+	}
+	if sessions, _ := reopened.ListBySID("sid3"); len(sessions) != 1 {
+		t.Errorf("ListBySID(sid3) after reopen returned %d sessions; want 1", len(sessions))
+	}
+}
+
+func TestFileSessionStoreCompaction(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sessions.jsonl")
+
+	store := openFileStore(t, path)
+	store.Add(storetest.NewSession("s1", "u1", ""))
+	for i := 0; i < 10; i++ {
+		store.Update("s1", func(session *models.SessionData) { session.LastSeen = time.Now() })
+	}
+	store.Add(storetest.NewSession("s2", "u1", ""))
+	store.Remove("s2")
+	if got := countLines(t, path); got != 13 {
+		t.Fatalf("log has %d records before close; want 13", got)
+	}
+
+	if err := store.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if got := countLines(t, path); got != 1 {
+		t.Errorf("log has %d records after compaction; want 1", got)
+	}
+}
+
+func TestFileSessionStoreTruncatedRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sessions.jsonl")
+
+	store := openFileStore(t, path)
+	store.Add(storetest.NewSession("s1", "u1", ""))
+	store.Close()
+
+	// A crash mid-write leaves a partial final line behind
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		t.Fatalf("open log: %v", err)
+	}
+	file.WriteString(`{"op":"put","session":{"sessionId":"s2"`)
+	file.Close()
+
+	reopened := openFileStore(t, path)
+	defer reopened.Close()
+	if _, exists, _ := reopened.Get("s1"); !exists {
+		t.Error("Get(s1) lost a complete record")
+	}
+	if _, exists, _ := reopened.Get("s2"); exists {
+		t.Error("Get(s2) loaded a truncated record")
+	}
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshot", "sessions.json")
+	store := services.NewMemorySessionStore()
+	live := storetest.NewSession("s1", "u1", "sid1")
+	live.ExpiresAt = time.Now().Add(time.Hour).UTC()
+	live.Tokens = &models.TokenSet{AccessToken: "access", RefreshToken: "refresh", IDToken: "id", Expiry: live.ExpiresAt}
+	expired := storetest.NewSession("s2", "u1", "sid2")
+	expired.ExpiresAt = time.Now().Add(-time.Minute).UTC()
+	store.Add(live)
+	store.Add(expired)
+
+	if saved, err := services.SaveSnapshot(store, path); err != nil || saved != 2 {
+		t.Fatalf("SaveSnapshot = %d, %v; want 2, nil", saved, err)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0o600 {
+		t.Fatalf("snapshot file: %v, %v; want mode 0600", info, err)
+	}
+
+	restored := services.NewMemorySessionStore()
+	if loaded, err := services.LoadSnapshot(restored, path); err != nil || loaded != 1 {
+		t.Fatalf("LoadSnapshot = %d, %v; want 1, nil", loaded, err)
+	}
+	got, exists, _ := restored.Get("s1")
+	if !exists || got.IdPSessionID != "sid1" || got.User.ID != "u1" || got.Tokens == nil || got.Tokens.RefreshToken != "refresh" || !got.ExpiresAt.Equal(live.ExpiresAt) {
+		t.Errorf("Get(s1) after restore = %+v, %v; want the saved session", got, exists)
+	}
+	if _, exists, _ := restored.Get("s2"); exists {
+		t.Error("expired session was restored")
+	}
+	// The snapshot is used once, so ended sessions cannot come back from it
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("snapshot still exists after loading: %v", err)
+	}
+}
+
+func TestLoadSnapshotMissingFile(t *testing.T) {
+	store := services.NewMemorySessionStore()
+	if loaded, err := services.LoadSnapshot(store, filepath.Join(t.TempDir(), "missing.json")); err != nil || loaded != 0 {
+		t.Errorf("LoadSnapshot = %d, %v; want 0, nil", loaded, err)
+	}
+}
+
+func TestLoadSnapshotCorruptFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sessions.json")
+	if err := os.WriteFile(path, []byte(`[{"sessionId":`), 0o600); err != nil {
+		t.Fatalf("write snapshot: %v", err)
+	}
+
+	if _, err := services.LoadSnapshot(services.NewMemorySessionStore(), path); err == nil {
+		t.Error("LoadSnapshot accepted a corrupt snapshot")
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("corrupt snapshot was removed: %v", err)
+	}
+}
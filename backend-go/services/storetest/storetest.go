@@ -0,0 +1,293 @@
+// Package storetest provides a conformance suite for services.SessionStore
+// implementations. Every backend should pass it:
+//
+//	func TestMyStore(t *testing.T) {
+//		storetest.Run(t, func(t *testing.T) services.SessionStore {
+//			return newMyStore(t)
+//		})
+//	}
+package storetest
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"keycloak-logout-backend-go/models"
+	"keycloak-logout-backend-go/services"
+)
+
+// Factory returns a new, empty store. It is called once per subtest; the
+// suite closes the store when the subtest ends.
+type Factory func(t *testing.T) services.SessionStore
+
+// Run executes the conformance suite against stores created by newStore
+func Run(t *testing.T, newStore Factory) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, store services.SessionStore)
+	}{
+		{"AddAndGet", testAddAndGet},
+		{"GetMissing", testGetMissing},
+		{"AddReplaces", testAddReplaces},
+		{"ListBySID", testListBySID},
+		{"Update", testUpdate},
+		{"UpdateMissing", testUpdateMissing},
+		{"Remove", testRemove},
+		{"List", testList},
+		{"ListForUser", testListForUser},
+		{"ReturnsCopies", testReturnsCopies},
+		{"Concurrent", testConcurrent},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newStore(t)
+			defer store.Close()
+			tt.fn(t, store)
+		})
+	}
+}
+
+// NewSession builds a session fixture of userID bound to IdP session sid.
+// Tests of the packages using a session store build their sessions with it
+// too.
+func NewSession(sessionID, userID, sid string) *models.SessionData {
+	session := &models.SessionData{
+		SessionID:    sessionID,
+		IdPSessionID: sid,
+		LoginTime:    time.Unix(1700000000, 0).UTC(),
+	}
+	session.User.ID = userID
+	session.User.Username = userID + "-name"
+	return session
+}
+
+func mustAdd(t *testing.T, store services.SessionStore, session *models.SessionData) {
+	t.Helper()
+	if err := store.Add(session); err != nil {
+		t.Fatalf("Add(%s): %v", session.SessionID, err)
+	}
+}
+
+func sessionIDs(sessions []*models.SessionData) []string {
+	ids := make([]string, 0, len(sessions))
+	for _, session := range sessions {
+		ids = append(ids, session.SessionID)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func equalIDs(got, want []string) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func testAddAndGet(t *testing.T, store services.SessionStore) {
+	mustAdd(t, store, NewSession("s1", "u1", "sid1"))
+
+	got, exists, err := store.Get("s1")
+	if err != nil || !exists {
+		t.Fatalf("Get(s1) = %v, %v, %v; want session", got, exists, err)
+	}
+	if got.User.ID != "u1" || got.IdPSessionID != "sid1" || got.User.Username != "u1-name" {
+		t.Errorf("Get(s1) = %+v; fields not preserved", got)
+	}
+	if !got.LoginTime.Equal(time.Unix(1700000000, 0)) {
+		t.Errorf("Get(s1).LoginTime = %v; want %v", got.LoginTime, time.Unix(1700000000, 0))
+	}
+}
+
+func testGetMissing(t *testing.T, store services.SessionStore) {
+	if _, exists, err := store.Get("missing"); exists || err != nil {
+		t.Errorf("Get(missing) = %v, %v; want false, nil", exists, err)
+	}
+	if sessions, err := store.ListBySID("missing"); len(sessions) != 0 || err != nil {
+		t.Errorf("ListBySID(missing) = %v, %v; want none, nil", sessionIDs(sessions), err)
+	}
+	if _, exists, err := store.Remove("missing"); exists || err != nil {
+		t.Errorf("Remove(missing) = %v, %v; want false, nil", exists, err)
+	}
+}
+
+func testAddReplaces(t *testing.T, store services.SessionStore) {
+	mustAdd(t, store, NewSession("s1", "u1", "sid1"))
+	mustAdd(t, store, NewSession("s1", "u1", "sid2"))
+
+	if sessions, _ := store.ListBySID("sid1"); len(sessions) != 0 {
+		t.Error("ListBySID(sid1) still finds the replaced session")
+	}
+	if got, _ := store.ListBySID("sid2"); !equalIDs(sessionIDs(got), []string{"s1"}) {
+		t.Errorf("ListBySID(sid2) = %v; want [s1]", sessionIDs(got))
+	}
+	if all, _ := store.List(); len(all) != 1 {
+		t.Errorf("List() returned %d sessions; want 1", len(all))
+	}
+}
+
+func testListBySID(t *testing.T, store services.SessionStore) {
+	mustAdd(t, store, NewSession("s1", "u1", "sid1"))
+	mustAdd(t, store, NewSession("s2", "u1", ""))
+	// A second login in the same browser shares the IdP session
+	mustAdd(t, store, NewSession("s3", "u1", "sid1"))
+
+	got, err := store.ListBySID("sid1")
+	if err != nil || !equalIDs(sessionIDs(got), []string{"s1", "s3"}) {
+		t.Errorf("ListBySID(sid1) = %v, %v; want [s1 s3]", sessionIDs(got), err)
+	}
+	if sessions, _ := store.ListBySID(""); len(sessions) != 0 {
+		t.Error("ListBySID(\"\") found a session without sid")
+	}
+
+	store.Remove("s1")
+	if got, _ := store.ListBySID("sid1"); !equalIDs(sessionIDs(got), []string{"s3"}) {
+		t.Errorf("ListBySID(sid1) after removing s1 = %v; want [s3]", sessionIDs(got))
+	}
+}
+
+func testUpdate(t *testing.T, store services.SessionStore) {
+	mustAdd(t, store, NewSession("s1", "u1", "sid1"))
+
+	seen := time.Unix(1700000100, 0).UTC()
+	updated, err := store.Update("s1", func(session *models.SessionData) {
+		session.LastSeen = seen
+		session.IdPSessionID = "sid2"
+	})
+	if err != nil || !updated {
+		t.Fatalf("Update(s1) = %v, %v; want true, nil", updated, err)
+	}
+
+	got, _, _ := store.Get("s1")
+	if !got.LastSeen.Equal(seen) {
+		t.Errorf("Get(s1).LastSeen = %v; want %v", got.LastSeen, seen)
+	}
+	if sessions, _ := store.ListBySID("sid1"); len(sessions) != 0 {
+		t.Error("ListBySID(sid1) still finds the session after its sid changed")
+	}
+	if got, _ := store.ListBySID("sid2"); !equalIDs(sessionIDs(got), []string{"s1"}) {
+		t.Errorf("ListBySID(sid2) = %v; want [s1]", sessionIDs(got))
+	}
+}
+
+func testUpdateMissing(t *testing.T, store services.SessionStore) {
+	called := false
+	updated, err := store.Update("missing", func(*models.SessionData) { called = true })
+	if err != nil || updated || called {
+		t.Errorf("Update(missing) = %v, %v (fn called: %v); want false, nil, not called", updated, err, called)
+	}
+	if _, exists, _ := store.Get("missing"); exists {
+		t.Error("Update(missing) created a session")
+	}
+}
+
+func testRemove(t *testing.T, store services.SessionStore) {
+	mustAdd(t, store, NewSession("s1", "u1", "sid1"))
+
+	removed, exists, err := store.Remove("s1")
+	if err != nil || !exists || removed.SessionID != "s1" {
+		t.Fatalf("Remove(s1) = %v, %v, %v; want s1", removed, exists, err)
+	}
+	if _, exists, _ := store.Get("s1"); exists {
+		t.Error("Get(s1) found a removed session")
+	}
+	if sessions, _ := store.ListBySID("sid1"); len(sessions) != 0 {
+		t.Error("ListBySID(sid1) found a removed session")
+	}
+	if sessions, _ := store.ListForUser("u1"); len(sessions) != 0 {
+		t.Errorf("ListForUser(u1) returned %d sessions after removal", len(sessions))
+	}
+	if _, exists, _ := store.Remove("s1"); exists {
+		t.Error("second Remove(s1) reported an existing session")
+	}
+}
+
+func testList(t *testing.T, store services.SessionStore) {
+	mustAdd(t, store, NewSession("s1", "u1", ""))
+	mustAdd(t, store, NewSession("s2", "u2", ""))
+	mustAdd(t, store, NewSession("s3", "u1", ""))
+
+	all, err := store.List()
+	if err != nil {
+		t.Fatalf("List(): %v", err)
+	}
+	if got, want := sessionIDs(all), []string{"s1", "s2", "s3"}; !equalIDs(got, want) {
+		t.Errorf("List() = %v; want %v", got, want)
+	}
+}
+
+func testListForUser(t *testing.T, store services.SessionStore) {
+	mustAdd(t, store, NewSession("s1", "u1", ""))
+	mustAdd(t, store, NewSession("s2", "u2", ""))
+	mustAdd(t, store, NewSession("s3", "u1", ""))
+
+	sessions, err := store.ListForUser("u1")
+	if err != nil {
+		t.Fatalf("ListForUser(u1): %v", err)
+	}
+	if got, want := sessionIDs(sessions), []string{"s1", "s3"}; !equalIDs(got, want) {
+		t.Errorf("ListForUser(u1) = %v; want %v", got, want)
+	}
+	if sessions, _ := store.ListForUser("nobody"); len(sessions) != 0 {
+		t.Errorf("ListForUser(nobody) returned %d sessions", len(sessions))
+	}
+}
+
+func testReturnsCopies(t *testing.T, store services.SessionStore) {
+	session := NewSession("s1", "u1", "")
+	mustAdd(t, store, session)
+	session.User.Username = "mutated-after-add"
+
+	got, _, _ := store.Get("s1")
+	if got.User.Username != "u1-name" {
+		t.Errorf("stored session changed after caller mutation: %q", got.User.Username)
+	}
+
+	got.User.Username = "mutated-after-get"
+	again, _, _ := store.Get("s1")
+	if again.User.Username != "u1-name" {
+		t.Errorf("stored session changed through returned value: %q", again.User.Username)
+	}
+}
+
+func testConcurrent(t *testing.T, store services.SessionStore) {
+	const workers = 8
+	const perWorker = 25
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < perWorker; i++ {
+				id := fmt.Sprintf("w%d-s%d", w, i)
+				if err := store.Add(NewSession(id, "u1", "")); err != nil {
+					t.Errorf("Add(%s): %v", id, err)
+					return
+				}
+				store.List()
+				if i%2 == 0 {
+					store.Remove(id)
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	sessions, err := store.ListForUser("u1")
+	if err != nil {
+		t.Fatalf("ListForUser(u1): %v", err)
+	}
+	if want := workers * (perWorker / 2); len(sessions) != want {
+		t.Errorf("ListForUser(u1) returned %d sessions; want %d", len(sessions), want)
+	}
+}
@@ -0,0 +1,189 @@
+package services
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/google/uuid"
+
+	"keycloak-logout-backend-go/metrics"
+	"keycloak-logout-backend-go/models"
+)
+
+// Transports through which subscribers receive session events
+const (
+	TransportSSE       = "sse"
+	TransportWebSocket = "ws"
+	TransportPoll      = "poll"
+)
+
+// Drop policies applied when a subscriber's queue is full
+const (
+	// DropOldest discards the oldest queued event to make room
+	DropOldest = "drop_oldest"
+	// DropDisconnect disconnects the subscriber; it resumes from the event
+	// log when it reconnects
+	DropDisconnect = "disconnect"
+	// DropCoalesce replaces a queued event of the same type with the new
+	// one, and otherwise discards the oldest
+	DropCoalesce = "coalesce"
+)
+
+// ValidateDropPolicy reports an error for an unknown drop policy
+func ValidateDropPolicy(policy string) error {
+	switch policy {
+	case DropOldest, DropDisconnect, DropCoalesce:
+		return nil
+	default:
+		return fmt.Errorf("unknown drop policy %q", policy)
+	}
+}
+
+// Subscriber receives the session events of one session, independent of
+// the transport that carries them to the browser
+type Subscriber interface {
+	ID() string
+	UserID() string
+	SessionID() string
+	Transport() string
+	// Send queues an event without blocking. It returns false if the
+	// subscriber cannot keep up and should be disconnected.
+	Send(event models.SessionEvent) bool
+	// Stats reports the subscriber's queue depth and dropped events
+	Stats() SubscriberStats
+	// Close tells the subscriber's connection to end. It is called once,
+	// when the subscriber is removed.
+	Close()
+}
+
+// SubscriberStats describes a subscriber and its queue
+type SubscriberStats struct {
+	ID        string `json:"id"`
+	UserID    string `json:"userId"`
+	SessionID string `json:"sessionId"`
+	Transport string `json:"transport"`
+	Queued    int    `json:"queued"`
+	Dropped   uint64 `json:"dropped"`
+}
+
+// QueueSubscriber is a Subscriber with a bounded queue, drained by the
+// connection handler of its transport: it waits on Ready and then takes the
+// queued events with Drain.
+type QueueSubscriber struct {
+	id        string
+	userID    string
+	sessionID string
+	transport string
+	size      int
+	policy    string
+
+	mu      sync.Mutex
+	queue   []models.SessionEvent
+	dropped uint64
+
+	ready     chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewQueueSubscriber creates a subscriber that queues up to size events and
+// applies policy when the queue is full
+func NewQueueSubscriber(transport, userID, sessionID string, size int, policy string) *QueueSubscriber {
+	return &QueueSubscriber{
+		id:        uuid.New().String(),
+		userID:    userID,
+		sessionID: sessionID,
+		transport: transport,
+		size:      size,
+		policy:    policy,
+		ready:     make(chan struct{}, 1),
+		done:      make(chan struct{}),
+	}
+}
+
+// ID implements Subscriber
+func (q *QueueSubscriber) ID() string { return q.id }
+
+// UserID implements Subscriber
+func (q *QueueSubscriber) UserID() string { return q.userID }
+
+// SessionID implements Subscriber
+func (q *QueueSubscriber) SessionID() string { return q.sessionID }
+
+// Transport implements Subscriber
+func (q *QueueSubscriber) Transport() string { return q.transport }
+
+// Send implements Subscriber, applying the drop policy to a full queue
+func (q *QueueSubscriber) Send(event models.SessionEvent) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.queue) >= q.size {
+		q.dropped++
+		metrics.Notifications.WithLabelValues(metrics.NotificationDropped).Inc()
+		switch q.policy {
+		case DropDisconnect:
+			return false
+		case DropCoalesce:
+			if i := q.indexOfTypeLocked(event.Type); i >= 0 {
+				q.queue = append(q.queue[:i], q.queue[i+1:]...)
+			} else {
+				q.queue = q.queue[1:]
+			}
+		default:
+			q.queue = q.queue[1:]
+		}
+	}
+
+	q.queue = append(q.queue, event)
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// indexOfTypeLocked returns the position of the oldest queued event of
+// eventType, or -1. Callers must hold mu.
+func (q *QueueSubscriber) indexOfTypeLocked(eventType string) int {
+	for i, queued := range q.queue {
+		if queued.Type == eventType {
+			return i
+		}
+	}
+	return -1
+}
+
+// Stats implements Subscriber
+func (q *QueueSubscriber) Stats() SubscriberStats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return SubscriberStats{
+		ID:        q.id,
+		UserID:    q.userID,
+		SessionID: q.sessionID,
+		Transport: q.transport,
+		Queued:    len(q.queue),
+		Dropped:   q.dropped,
+	}
+}
+
+// Close implements Subscriber
+func (q *QueueSubscriber) Close() {
+	q.closeOnce.Do(func() { close(q.done) })
+}
+
+// Ready receives a value when events have been queued since the last Drain
+func (q *QueueSubscriber) Ready() <-chan struct{} { return q.ready }
+
+// Drain removes and returns the queued events, oldest first
+func (q *QueueSubscriber) Drain() []models.SessionEvent {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	events := q.queue
+	q.queue = nil
+	return events
+}
+
+// Done is closed when the subscriber has been removed
+func (q *QueueSubscriber) Done() <-chan struct{} { return q.done }
@@ -0,0 +1,88 @@
+package services_test
+
+import (
+	"testing"
+
+	"keycloak-logout-backend-go/models"
+	"keycloak-logout-backend-go/services"
+)
+
+func TestQueueSubscriberDropPolicies(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   string
+		sent     []models.SessionEvent
+		accepted bool // whether the last event was accepted
+		want     []string
+	}{
+		{
+			name:     "drop_oldest discards the oldest event",
+			policy:   services.DropOldest,
+			sent:     []models.SessionEvent{{ID: "e1", Type: "a"}, {ID: "e2", Type: "b"}, {ID: "e3", Type: "c"}},
+			accepted: true,
+			want:     []string{"e2", "e3"},
+		},
+		{
+			name:     "disconnect rejects the event",
+			policy:   services.DropDisconnect,
+			sent:     []models.SessionEvent{{ID: "e1", Type: "a"}, {ID: "e2", Type: "b"}, {ID: "e3", Type: "c"}},
+			accepted: false,
+			want:     []string{"e1", "e2"},
+		},
+		{
+			name:     "coalesce replaces an event of the same type",
+			policy:   services.DropCoalesce,
+			sent:     []models.SessionEvent{{ID: "e1", Type: "a"}, {ID: "e2", Type: "b"}, {ID: "e3", Type: "b"}},
+			accepted: true,
+			want:     []string{"e1", "e3"},
+		},
+		{
+			name:     "coalesce without an event of the same type discards the oldest",
+			policy:   services.DropCoalesce,
+			sent:     []models.SessionEvent{{ID: "e1", Type: "a"}, {ID: "e2", Type: "b"}, {ID: "e3", Type: "c"}},
+			accepted: true,
+			want:     []string{"e2", "e3"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sub := services.NewQueueSubscriber(services.TransportSSE, "user-1", "s1", 2, tt.policy)
+			var accepted bool
+			for _, event := range tt.sent {
+				accepted = sub.Send(event)
+			}
+
+			if accepted != tt.accepted {
+				t.Errorf("last Send returned %v; want %v", accepted, tt.accepted)
+			}
+			if stats := sub.Stats(); stats.Dropped != 1 || stats.Queued != 2 {
+				t.Errorf("dropped %d, queued %d; want 1 and 2", stats.Dropped, stats.Queued)
+			}
+			select {
+			case <-sub.Ready():
+			default:
+				t.Error("Ready was not signalled")
+			}
+			var got []string
+			for _, event := range sub.Drain() {
+				got = append(got, event.ID)
+			}
+			if !equalStrings(got, tt.want) {
+				t.Errorf("drained %v; want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQueueSubscriberClose(t *testing.T) {
+	sub := services.NewQueueSubscriber(services.TransportWebSocket, "user-1", "s1", 2, services.DropOldest)
+	sub.Close()
+	sub.Close()
+
+	select {
+	case <-sub.Done():
+	default:
+		t.Error("Done is not closed after Close")
+	}
+}
@@ -0,0 +1,146 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"golang.org/x/oauth2"
+
+	"keycloak-logout-backend-go/models"
+)
+
+// ErrSessionNotFound is returned when a session no longer exists
+var ErrSessionNotFound = errors.New("session not found")
+
+// ErrRefreshRevoked is returned when the IdP rejects a session's refresh
+// token, which means the IdP session has ended
+var ErrRefreshRevoked = errors.New("refresh token rejected by identity provider")
+
+// oauth2ExpiryDelta matches the early-expiry margin of oauth2.Token.Valid
+const oauth2ExpiryDelta = 10 * time.Second
+
+// TokenManager refreshes the OAuth2 tokens of active sessions and stores
+// the refreshed tokens with the session
+type TokenManager struct {
+	authService    *AuthService
+	sessionService *SessionService
+}
+
+// NewTokenManager creates a new token manager
+func NewTokenManager(authSvc *AuthService, sessionSvc *SessionService) *TokenManager {
+	return &TokenManager{
+		authService:    authSvc,
+		sessionService: sessionSvc,
+	}
+}
+
+// NewTokenSet converts an OAuth2 token into the form stored with a session
+func NewTokenSet(token *oauth2.Token) *models.TokenSet {
+	tokens := &models.TokenSet{
+		AccessToken:  token.AccessToken,
+		RefreshToken: token.RefreshToken,
+		TokenType:    token.TokenType,
+		Expiry:       token.Expiry,
+	}
+	if rawIDToken, ok := token.Extra("id_token").(string); ok {
+		tokens.IDToken = rawIDToken
+	}
+	return tokens
+}
+
+// StartRefresher refreshes, every interval, the tokens of sessions used
+// within activeWindow that expire before the next run, so that ended IdP
+// sessions are detected without waiting for a request. Idle sessions are
+// not refreshed, which would keep their IdP sessions alive; the sweeper ends
+// them.
+func (m *TokenManager) StartRefresher(ctx context.Context, interval, activeWindow time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				m.refreshExpiring(ctx, now, now.Add(interval+oauth2ExpiryDelta), activeWindow)
+			}
+		}
+	}()
+}
+
+// refreshExpiring refreshes the tokens of recently active sessions expiring
+// before the deadline
+func (m *TokenManager) refreshExpiring(ctx context.Context, now, before time.Time, activeWindow time.Duration) {
+	for _, session := range m.sessionService.GetAllSessions(ctx) {
+		tokens := session.Tokens
+		if tokens == nil || tokens.RefreshToken == "" || tokens.Expiry.IsZero() || tokens.Expiry.After(before) {
+			continue
+		}
+		if !recentlyActive(session, now, activeWindow) {
+			continue
+		}
+		if err := m.refresh(ctx, session); err != nil {
+			slog.WarnContext(ctx, "token refresh failed", "session_id", session.SessionID, "error", err)
+		}
+	}
+}
+
+// recentlyActive reports whether a session was used within window
+func recentlyActive(session *models.SessionData, now time.Time, window time.Duration) bool {
+	lastSeen := session.LastSeen
+	if lastSeen.IsZero() {
+		lastSeen = session.LoginTime
+	}
+	return now.Sub(lastSeen) <= window
+}
+
+// refresh obtains new tokens for a session and stores them. If the IdP
+// rejects the refresh token with invalid_grant the session is invalidated
+// and its clients are notified.
+func (m *TokenManager) refresh(ctx context.Context, session *models.SessionData) error {
+	sessionID := session.SessionID
+	refreshed, err := m.authService.RefreshToken(ctx, session.Tokens.RefreshToken)
+	if err != nil {
+		var retrieveErr *oauth2.RetrieveError
+		if errors.As(err, &retrieveErr) && retrieveErr.ErrorCode == "invalid_grant" {
+			slog.InfoContext(ctx, "refresh token rejected, invalidating session", "session_id", sessionID)
+			if removed, exists := m.sessionService.RemoveSessionByID(ctx, sessionID); exists {
+				m.sessionService.NotifySessionInvalidated(removed, ReasonRefreshRevoked)
+			}
+			return ErrRefreshRevoked
+		}
+		return fmt.Errorf("token refresh failed: %w", err)
+	}
+
+	tokens := NewTokenSet(refreshed)
+	if tokens.RefreshToken == "" {
+		tokens.RefreshToken = session.Tokens.RefreshToken
+	}
+
+	// A new ID token carries the current roles and groups. If it does not
+	// verify, the rotated tokens are stored anyway, since the old refresh
+	// token is no longer valid, and the session keeps its previous ID token
+	// and profile.
+	var profile *models.UserProfile
+	var idTokenErr error
+	if tokens.IDToken != "" {
+		claims, err := m.authService.VerifyRefreshedIDToken(ctx, tokens.IDToken)
+		if err == nil {
+			refreshedProfile := m.authService.ExtractUserProfile(claims)
+			profile = &refreshedProfile
+		} else {
+			idTokenErr = fmt.Errorf("refreshed ID token rejected, keeping the previous profile: %w", err)
+			tokens.IDToken = session.Tokens.IDToken
+		}
+	} else {
+		tokens.IDToken = session.Tokens.IDToken
+	}
+
+	if err := m.sessionService.UpdateTokens(sessionID, tokens, profile); err != nil {
+		return err
+	}
+	return idTokenErr
+}
@@ -0,0 +1,133 @@
+package services_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"keycloak-logout-backend-go/models"
+	"keycloak-logout-backend-go/services"
+	"keycloak-logout-backend-go/services/oidctest"
+	"keycloak-logout-backend-go/services/storetest"
+)
+
+const (
+	testRefreshInterval = 20 * time.Millisecond
+	testActiveWindow    = time.Minute
+)
+
+// refreshFixture runs a token refresher against a stand-in realm
+type refreshFixture struct {
+	idp      *oidctest.Provider
+	auth     *services.AuthService
+	sessions *services.SessionService
+}
+
+func newRefreshFixture(t *testing.T) *refreshFixture {
+	t.Helper()
+	authService, idp := newTestAuthService(t)
+	sessionService := newTestSessionService(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	services.NewTokenManager(authService, sessionService).StartRefresher(ctx, testRefreshInterval, testActiveWindow)
+	return &refreshFixture{idp: idp, auth: authService, sessions: sessionService}
+}
+
+// addSession adds a session of user-1 last used at lastSeen whose access
+// token expires at expiry, and returns its refresh token
+func (f *refreshFixture) addSession(t *testing.T, sessionID string, lastSeen, expiry time.Time) string {
+	t.Helper()
+	refreshToken := f.idp.IssueRefreshToken("user-1")
+	session := storetest.NewSession(sessionID, "user-1", "")
+	session.User.Username = "before-refresh"
+	session.LastSeen = lastSeen
+	session.Tokens = &models.TokenSet{
+		AccessToken:  "old-access-token",
+		RefreshToken: refreshToken,
+		IDToken:      "old-id-token",
+		Expiry:       expiry,
+	}
+	if err := f.sessions.AddSession(session); err != nil {
+		t.Fatalf("AddSession: %v", err)
+	}
+	return refreshToken
+}
+
+func TestRefresherRefreshesActiveSession(t *testing.T) {
+	f := newRefreshFixture(t)
+	oldRefreshToken := f.addSession(t, "s1", time.Now(), time.Now())
+
+	waitFor(t, 5*time.Second, "token refresh", func() bool {
+		session, exists := f.sessions.GetSession(context.Background(), "s1")
+		return exists && session.Tokens.AccessToken != "old-access-token"
+	})
+
+	session, _ := f.sessions.GetSession(context.Background(), "s1")
+	if session.Tokens.RefreshToken == oldRefreshToken {
+		t.Error("rotated refresh token was not stored")
+	}
+	if session.Tokens.IDToken == "old-id-token" || session.User.Username != "user-1" {
+		t.Errorf("ID token %q, username %q; want the refreshed ID token and profile", session.Tokens.IDToken, session.User.Username)
+	}
+}
+
+func TestRefresherSkipsUnexpiredAndIdleSessions(t *testing.T) {
+	f := newRefreshFixture(t)
+	f.addSession(t, "valid", time.Now(), time.Now().Add(time.Hour))
+	f.addSession(t, "idle", time.Now().Add(-time.Hour), time.Now())
+
+	time.Sleep(10 * testRefreshInterval)
+	if grants := f.idp.RefreshGrants(); grants != 0 {
+		t.Errorf("%d refresh grants; want none", grants)
+	}
+	for _, id := range []string{"valid", "idle"} {
+		if session, exists := f.sessions.GetSession(context.Background(), id); !exists || session.Tokens.AccessToken != "old-access-token" {
+			t.Errorf("session %s was refreshed or removed", id)
+		}
+	}
+}
+
+func TestRefresherInvalidatesSessionOnInvalidGrant(t *testing.T) {
+	f := newRefreshFixture(t)
+	f.addSession(t, "s1", time.Now(), time.Now())
+	sub := f.sessions.Subscribe(services.TransportSSE, "user-1", "s1")
+	f.idp.RevokeRefreshTokens()
+
+	select {
+	case <-sub.Ready():
+		events := sub.Drain()
+		if len(events) != 1 || events[0].Type != services.EventSessionInvalidated || events[0].Reason != services.ReasonRefreshRevoked {
+			t.Errorf("got %+v; want one session_invalidated with refresh_token_revoked", events)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("subscriber was not notified")
+	}
+	if _, exists := f.sessions.GetSession(context.Background(), "s1"); exists {
+		t.Error("session still exists after invalid_grant")
+	}
+}
+
+func TestRefresherKeepsRotatedTokensWhenIDTokenIsRejected(t *testing.T) {
+	f := newRefreshFixture(t)
+	f.idp.SignRefreshedIDTokensWithUnpublishedKey()
+	oldRefreshToken := f.addSession(t, "s1", time.Now(), time.Now())
+
+	waitFor(t, 5*time.Second, "token refresh", func() bool {
+		session, exists := f.sessions.GetSession(context.Background(), "s1")
+		return exists && session.Tokens.AccessToken != "old-access-token"
+	})
+
+	session, _ := f.sessions.GetSession(context.Background(), "s1")
+	if session.Tokens.RefreshToken == oldRefreshToken {
+		t.Error("rotated refresh token was discarded")
+	}
+	if session.Tokens.IDToken != "old-id-token" || session.User.Username != "before-refresh" {
+		t.Errorf("ID token %q, username %q; want the previous ID token and profile", session.Tokens.IDToken, session.User.Username)
+	}
+
+	// The stored refresh token is the live one, so the next refresh works
+	if _, err := f.auth.RefreshToken(context.Background(), session.Tokens.RefreshToken); err != nil {
+		t.Errorf("refresh with the stored token: %v", err)
+	}
+}
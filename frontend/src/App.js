@@ -25,7 +25,8 @@ function App() {
       const safeUser = {
         id: String(userData.id || 'Unknown'),
         name: String(userData.name || 'Unknown'),
-        email: String(userData.email || 'No email')
+        email: String(userData.email || 'No email'),
+        isAdmin: userData.isAdmin === true
       };
       
       setUser(safeUser);
@@ -49,10 +50,10 @@ function App() {
     }
   };
 
-  // 활성 세션 조회
+  // 활성 세션 조회 (관리자 전용 API)
   const fetchActiveSessions = async () => {
     try {
-      const response = await axios.get(`${API_BASE_URL}/api/sessions`, {
+      const response = await axios.get(`${API_BASE_URL}/api/admin/sessions`, {
         withCredentials: true
       });
       setSessions(response.data.sessions);
@@ -85,9 +86,17 @@ function App() {
   useEffect(() => {
     checkAuthStatus();
     checkSessionStatus();
-    fetchActiveSessions();
   }, []);
 
+  // 관리자만 전체 세션 목록 조회
+  useEffect(() => {
+    if (user && user.isAdmin) {
+      fetchActiveSessions();
+    } else {
+      setSessions([]);
+    }
+  }, [user]);
+
   // SSE 연결 관리 (사용자 로그인 후)
   useEffect(() => {
     if (!user) return;
@@ -103,16 +112,25 @@ function App() {
       setSseConnected(true);
     };
 
-    eventSource.onmessage = (event) => {
-      console.log('📨 SSE message received:', event.data);
-      
-      if (event.data === 'connected') {
-        console.log('🎉 SSE initial connection confirmed');
-      } else if (event.data === 'session_invalidated') {
-        console.log('🚨 Session invalidated via SSE!');
-        checkSessionStatus();
-      }
+    // 서버는 이벤트 타입을 SSE event 이름으로, JSON(SessionEvent)을 data로 전송
+    eventSource.addEventListener('connected', () => {
+      console.log('🎉 SSE initial connection confirmed');
+    });
+
+    const handleSessionEnded = (event) => {
+      const sessionEvent = JSON.parse(event.data);
+      console.log('🚨 Session ended via SSE:', sessionEvent.type, sessionEvent.reason);
+      checkSessionStatus();
     };
+    eventSource.addEventListener('session_invalidated', handleSessionEnded);
+    eventSource.addEventListener('session_expired', handleSessionEnded);
+
+    // The backend is restarting; EventSource reconnects after the retry hint
+    eventSource.addEventListener('server_shutdown', (event) => {
+      const shutdownEvent = JSON.parse(event.data);
+      console.log('🔄 Server shutting down, reconnecting in', shutdownEvent.retryMs, 'ms');
+      setSseConnected(false);
+    });
 
     eventSource.onerror = (error) => {
       console.error('❌ SSE error:', error);
@@ -198,6 +216,7 @@ function App() {
           </div>
         )}
         
+        {user && user.isAdmin && (
         <div className="sessions-section">
           <h3>활성 세션 목록</h3>
           {sessions.length > 0 ? (
@@ -214,6 +233,7 @@ function App() {
             <p>활성 세션이 없습니다.</p>
           )}
         </div>
+        )}
         
         <div className="info-section">
           <h3>테스트 방법</h3>
@@ -228,7 +248,7 @@ function App() {
             <h4>백엔드 엔드포인트</h4>
             <p><code>POST /auth/backchannel-logout</code> - Backchannel Logout 수신</p>
             <p><code>GET /api/session-status</code> - 현재 세션 상태 확인</p>
-            <p><code>GET /api/sessions</code> - 활성 세션 목록</p>
+            <p><code>GET /api/admin/sessions</code> - 활성 세션 목록 (관리자)</p>
           </div>
         </div>
       </div>